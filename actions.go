@@ -0,0 +1,297 @@
+package main
+
+import (
+	"fmt"
+	"github.com/gpayer/go-audio-service/notes"
+	mt "gopkg.in/music-theory.v0/note"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// What pressing a pad does
+type PadAction string
+
+const (
+	ActionGiveUp     PadAction = "give-up"
+	ActionRetry      PadAction = "retry"
+	ActionContinue   PadAction = "continue"
+	ActionHint       PadAction = "hint"
+	ActionReplay     PadAction = "replay"
+	ActionUndo       PadAction = "undo"
+	ActionSkip       PadAction = "skip"
+	ActionBury       PadAction = "bury"
+	ActionSuspend    PadAction = "suspend"
+	ActionEndSession PadAction = "end-session"
+	ActionStats      PadAction = "stats"
+
+	// The original four pads, which do different things as an exercise goes on
+	ActionPadA PadAction = "pad-a"
+	ActionPadB PadAction = "pad-b"
+	ActionPadC PadAction = "pad-c"
+	ActionPadD PadAction = "pad-d"
+)
+
+// Grade actions are written grade-0 to grade-5, from forgotten to perfect
+const gradeActionPrefix = "grade-"
+
+const MaxGrade = 5
+
+// Continuing from a finished exercise grades it as a failure, or with this
+// grade if it was passed
+const ContinueGrade = 4
+
+var PadActions = []PadAction{
+	ActionGiveUp, ActionRetry, ActionContinue, ActionHint, ActionReplay, ActionUndo, ActionSkip, ActionBury,
+	ActionSuspend, ActionEndSession, ActionStats, ActionPadA, ActionPadB, ActionPadC, ActionPadD,
+}
+
+// What the original pads do in each state of an exercise, and their labels
+var legacyActions = map[PadAction]map[ExerciseState]PadAction{
+	ActionPadA: {ExerciseInProgress: ActionGiveUp, ExerciseFail: ActionRetry, ExercisePass: gradeAction(3)},
+	ActionPadB: {ExerciseInProgress: ActionHint, ExerciseFail: gradeAction(0), ExercisePass: gradeAction(4)},
+	ActionPadC: {ExerciseInProgress: ActionBury, ExerciseFail: gradeAction(0), ExercisePass: gradeAction(5)},
+	ActionPadD: {ExerciseInProgress: ActionSuspend, ExerciseFail: gradeAction(0), ExercisePass: gradeAction(5)},
+}
+
+var legacyLabels = map[PadAction]map[ExerciseState]string{
+	ActionPadA: {ExerciseInProgress: "Give up", ExerciseFail: "Retry", ExercisePass: "Easy"},
+	ActionPadB: {ExerciseInProgress: "Hint", ExerciseFail: "Continue", ExercisePass: "Normal"},
+	ActionPadC: {ExerciseInProgress: "Bury", ExercisePass: "Hard"},
+	ActionPadD: {ExerciseInProgress: "Suspend"},
+}
+
+var actionLabels = map[PadAction]string{
+	ActionGiveUp:     "Give up",
+	ActionRetry:      "Retry",
+	ActionContinue:   "Continue",
+	ActionHint:       "Hint",
+	ActionReplay:     "Listen",
+	ActionUndo:       "Undo",
+	ActionSkip:       "Skip",
+	ActionBury:       "Bury",
+	ActionSuspend:    "Suspend",
+	ActionEndSession: "End session",
+	ActionStats:      "Stats",
+}
+
+func gradeAction(difficulty uint) PadAction {
+	return PadAction(fmt.Sprintf("%s%d", gradeActionPrefix, difficulty))
+}
+
+// The grade given by an action, if it's a grade action
+func actionGrade(action PadAction) (uint, bool) {
+	if !strings.HasPrefix(string(action), gradeActionPrefix) {
+		return 0, false
+	}
+
+	difficulty, err := strconv.ParseUint(strings.TrimPrefix(string(action), gradeActionPrefix), 10, 32)
+	if err != nil || difficulty > MaxGrade {
+		return 0, false
+	}
+
+	return uint(difficulty), true
+}
+
+func ParseAction(s string) (PadAction, error) {
+	action := PadAction(strings.ToLower(strings.TrimSpace(s)))
+	if _, ok := actionGrade(action); ok {
+		return action, nil
+	}
+
+	for _, a := range PadActions {
+		if action == a {
+			return action, nil
+		}
+	}
+
+	return action, fmt.Errorf("unknown action %q (use grade-0 to grade-5, %s)", s, joinActions(PadActions))
+}
+
+func joinActions(actions []PadAction) string {
+	names := []string{}
+	for _, action := range actions {
+		names = append(names, string(action))
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// Whether a grade can be given in an exercise state. Failed exercises can only
+// be graded as failures.
+func canGrade(difficulty uint, state ExerciseState) bool {
+	return state == ExercisePass || (state == ExerciseFail && difficulty < 3)
+}
+
+// The action an original pad stands for in an exercise state
+func resolveAction(action PadAction, state ExerciseState) PadAction {
+	if actions, ok := legacyActions[action]; ok {
+		return actions[state]
+	}
+
+	return action
+}
+
+// The label shown for a pad during an exercise, or nothing if the pad does
+// nothing at the moment
+func (a *App) actionLabel(action PadAction) string {
+	s := a.stateInSession.state
+	if labels, ok := legacyLabels[action]; ok {
+		return labels[s]
+	}
+
+	if difficulty, ok := actionGrade(action); ok {
+		if canGrade(difficulty, s) {
+			return fmt.Sprintf("Grade %d", difficulty)
+		}
+		return ""
+	}
+
+	switch action {
+	case ActionGiveUp, ActionHint, ActionSkip, ActionBury, ActionSuspend:
+		if s != ExerciseInProgress {
+			return ""
+		}
+	case ActionRetry:
+		if s != ExerciseFail {
+			return ""
+		}
+	case ActionContinue:
+		if s == ExerciseInProgress {
+			return ""
+		}
+	case ActionUndo:
+		if len(a.stateInSession.undo) == 0 {
+			return ""
+		}
+	}
+
+	return actionLabels[action]
+}
+
+// Carry out a pad's action. At home, pads other than undo and stats start a
+// session.
+func (a *App) doAction(action PadAction) {
+	switch a.state {
+	case StateHome:
+		switch action {
+		case ActionUndo:
+			a.undo()
+		case ActionStats:
+			a.toggleStats()
+		default:
+			a.startSession()
+		}
+		return
+	case StateStats:
+		if action == ActionStats {
+			a.toggleStats()
+		}
+		return
+	case StateInSession:
+	default:
+		return
+	}
+
+	s := a.stateInSession.state
+	action = resolveAction(action, s)
+
+	if difficulty, ok := actionGrade(action); ok {
+		if canGrade(difficulty, s) {
+			a.grade(difficulty)
+			a.nextExercise()
+		}
+		return
+	}
+
+	switch action {
+	case ActionGiveUp:
+		if s == ExerciseInProgress {
+			a.stateInSession.state = ExerciseFail
+		}
+	case ActionRetry:
+		if s == ExerciseFail {
+			a.stateInSession.currentExercise.Reset()
+			a.stateInSession.state = ExerciseInProgress
+		}
+	case ActionContinue:
+		switch s {
+		case ExerciseFail:
+			a.grade(0)
+			a.nextExercise()
+		case ExercisePass:
+			a.grade(ContinueGrade)
+			a.nextExercise()
+		}
+	case ActionHint:
+		if s == ExerciseInProgress {
+			a.stateInSession.showHint = true
+		}
+	case ActionReplay:
+		a.playExercise()
+	case ActionUndo:
+		a.undo()
+	case ActionSkip:
+		if s == ExerciseInProgress {
+			a.nextExercise()
+		}
+	case ActionBury:
+		a.setAsideCurrentCard(false)
+	case ActionSuspend:
+		a.setAsideCurrentCard(true)
+	case ActionEndSession:
+		a.goHome()
+	case ActionStats:
+		a.toggleStats()
+	}
+}
+
+// How long each part of an exercise sounds for when it's played
+const PlaybackNoteLength = 600 * time.Millisecond
+
+// Exercises are played upwards from middle C
+const PlaybackStartKey = 60
+
+// Play the current exercise through the synth, one part at a time, without
+// holding up input
+func (a *App) playExercise() {
+	parts := exerciseKeys(a.stateInSession.currentExercise.Definition.Parts)
+
+	go func() {
+		for _, keys := range parts {
+			for _, key := range keys {
+				a.sendNote(notes.Pressed, key, KeyboardVelocity)
+			}
+			time.Sleep(PlaybackNoteLength)
+			for _, key := range keys {
+				a.sendNote(notes.Released, key, 0)
+			}
+		}
+	}()
+}
+
+// MIDI keys for an exercise's notes, each above the one before so chords are
+// in root position and scales go up
+func exerciseKeys(parts [][]mt.Class) [][]uint8 {
+	keys := [][]uint8{}
+	previous := PlaybackStartKey - 1
+	for _, part := range parts {
+		partKeys := []uint8{}
+		for _, class := range part {
+			if class == mt.Nil {
+				continue
+			}
+
+			key := previous + 1
+			for key%12 != int(class)-1 {
+				key++
+			}
+
+			partKeys = append(partKeys, uint8(key))
+			previous = key
+		}
+		keys = append(keys, partKeys)
+	}
+
+	return keys
+}
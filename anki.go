@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Anki's text importer reads these headers to configure itself. The GUID column
+// lets a later export update notes already imported rather than duplicate them.
+const ankiHeader = "#separator:tab\n#html:false\n#guid column:1\n#tags column:%d\n"
+
+// Remove characters which would break a tab-separated field
+func ankiField(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// Anki tags are separated by spaces
+func ankiTag(s string) string {
+	return strings.ReplaceAll(strings.TrimSpace(s), " ", "_")
+}
+
+// Write cards as a tab-separated file for Anki's "Import File" dialog, with
+// the prompt on the front and the spelled-out notes on the back. Anki can't
+// import review history from text, so with scheduling the card's interval,
+// ease and due date are added as extra fields for a note type which has them.
+func WriteAnkiTSV(w io.Writer, cards []Card, scheduling bool) error {
+	tagsColumn := 4
+	if scheduling {
+		tagsColumn = 7
+	}
+
+	if _, err := fmt.Fprintf(w, ankiHeader, tagsColumn); err != nil {
+		return err
+	}
+
+	for _, card := range cards {
+		fields := []string{
+			card.ID,
+			card.DisplayName(),
+			SpellExercise(card),
+		}
+
+		if scheduling {
+			due := ""
+			if !card.LastRecalledAt.IsZero() {
+				due = NextRecallTime(card).Format("2006-01-02")
+			}
+
+			fields = append(fields,
+				fmt.Sprint(card.Interval),
+				// Anki stores ease as a percentage, e.g. 250%
+				fmt.Sprintf("%.0f", card.Ef*100),
+				due,
+			)
+		}
+
+		tags := []string{ankiTag(card.ExerciseType)}
+		for _, tag := range card.Tags {
+			tags = append(tags, ankiTag(tag))
+		}
+		fields = append(fields, strings.Join(tags, " "))
+
+		for i := range fields {
+			fields[i] = ankiField(fields[i])
+		}
+
+		if _, err := fmt.Fprintln(w, strings.Join(fields, "\t")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,220 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Setting recording when the database was last backed up, in RFC 3339 format
+const LastBackupSetting = "LastBackup"
+
+// How often the running app backs up the database, on top of the backup
+// made before each session
+const BackupInterval = 24 * time.Hour
+
+const backupTimeFormat = "20060102-150405.000"
+
+// Backups are kept in a directory next to the database
+func BackupDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "backups")
+}
+
+func (self *BoltStore) Backup(path string) error {
+	return self.db.View(func(tx *bolt.Tx) error {
+		return writeFileAtomically(path, func(w io.Writer) error {
+			_, err := tx.WriteTo(w)
+			return err
+		})
+	})
+}
+
+func (self *SQLiteStore) Backup(path string) error {
+	// VACUUM INTO refuses to overwrite files, so write to a fresh temporary name
+	tmp := path + TempSuffix
+	os.Remove(tmp)
+
+	if _, err := self.db.Exec("VACUUM INTO ?", tmp); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Added to the name of a file while it's being written
+const TempSuffix = ".tmp"
+
+// Write a file via a temporary file, so a failed write never leaves a partial file behind
+func writeFileAtomically(path string, write func(w io.Writer) error) error {
+	tmp := path + TempSuffix
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// Snapshot the store into the backups directory, then delete all but the
+// newest keep backups. Returns the path of the new backup.
+func BackupStore(store Store, dbPath string, keep int) (string, error) {
+	dir := BackupDir(dbPath)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	base := filepath.Base(dbPath)
+	ext := filepath.Ext(base)
+	name := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(base, ext), now.Format(backupTimeFormat), ext)
+	path := filepath.Join(dir, name)
+
+	if err := store.Backup(path); err != nil {
+		return "", err
+	}
+
+	if err := store.SetSetting(LastBackupSetting, now.Format(time.RFC3339)); err != nil {
+		return path, err
+	}
+
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		return path, err
+	}
+
+	for keep > 0 && len(backups) > keep {
+		oldest := backups[len(backups)-1]
+		if err := os.Remove(oldest); err != nil {
+			return path, err
+		}
+		backups = backups[:len(backups)-1]
+	}
+
+	return path, nil
+}
+
+// Whether the store hasn't been backed up within the backup interval
+func BackupDue(store Store, now time.Time) bool {
+	last, err := store.Setting(LastBackupSetting)
+	if err != nil || last == "" {
+		return true
+	}
+
+	t, err := time.Parse(time.RFC3339, last)
+	if err != nil {
+		return true
+	}
+
+	return now.Sub(t) >= BackupInterval
+}
+
+// Backups of the database at dbPath, newest first
+func ListBackups(dbPath string) ([]string, error) {
+	base := filepath.Base(dbPath)
+	ext := filepath.Ext(base)
+	pattern := fmt.Sprintf("%s-*%s", strings.TrimSuffix(base, ext), ext)
+
+	matches, err := filepath.Glob(filepath.Join(BackupDir(dbPath), pattern))
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip backups still being written, or left behind by a failed write
+	backups := []string{}
+	for _, match := range matches {
+		if !strings.HasSuffix(match, TempSuffix) {
+			backups = append(backups, match)
+		}
+	}
+
+	// Timestamps in the names sort chronologically
+	sort.Sort(sort.Reverse(sort.StringSlice(backups)))
+	return backups, nil
+}
+
+// Replace the database with a backup. The current database is backed up first,
+// so a restore can itself be undone.
+func RestoreBackup(backup, dbPath string) error {
+	if _, err := os.Stat(backup); err != nil {
+		return err
+	}
+
+	// Make sure the backup is readable before replacing anything
+	if err := checkBackup(backup); err != nil {
+		return fmt.Errorf("could not open backup: %v", err)
+	}
+
+	if _, err := os.Stat(dbPath); err == nil {
+		current, err := ConnectWithoutMigrating(dbPath)
+		if err != nil {
+			return err
+		}
+
+		// Don't rotate, which could delete the backup being restored
+		_, err = BackupStore(current, dbPath, 0)
+		current.Close()
+		if err != nil {
+			return fmt.Errorf("could not back up current database: %v", err)
+		}
+	}
+
+	return writeFileAtomically(dbPath, func(w io.Writer) error {
+		in, err := os.Open(backup)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+
+		_, err = io.Copy(w, in)
+		return err
+	})
+}
+
+// Check a backup can be opened, without modifying it
+func checkBackup(path string) error {
+	if isSQLitePath(path) {
+		store, err := ConnectSQLiteWithoutMigrating(path)
+		if err != nil {
+			return err
+		}
+		store.Close()
+		return nil
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(CardBucket) == nil {
+			return errors.New("no cards found")
+		}
+		return nil
+	})
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"gitlab.com/gomidi/midi"
+	"gitlab.com/gomidi/midi/midimessage/channel"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type BindingKind uint8
+
+const (
+	BindNote BindingKind = iota
+	BindControl
+	// Program changes have no release, so they press and release a pad at once
+	BindProgram
+)
+
+var bindingKindNames = map[BindingKind]string{BindNote: "note", BindControl: "cc", BindProgram: "pc"}
+
+// Matches messages on every channel
+const AnyChannel = -1
+
+// The MIDI message which presses a pad. Bindings are written as the kind,
+// number and an optional channel from 1 to 16, e.g. "note:40@10", "cc:20" or
+// "pc:3@10", and a plain number is a note. Bindings without a channel match
+// the pad channel if there is one, or any channel otherwise.
+type PadBinding struct {
+	Kind    BindingKind
+	Number  uint8
+	Channel int
+}
+
+func ParseBinding(s string) (PadBinding, error) {
+	binding := PadBinding{Kind: BindNote, Channel: AnyChannel}
+
+	spec := strings.ToLower(strings.TrimSpace(s))
+	if i := strings.Index(spec, ":"); i >= 0 {
+		found := false
+		for kind, name := range bindingKindNames {
+			if spec[:i] == name {
+				binding.Kind = kind
+				found = true
+			}
+		}
+		if !found {
+			return binding, fmt.Errorf("unknown message type in binding %q (use note, cc or pc)", s)
+		}
+		spec = spec[i+1:]
+	}
+
+	if i := strings.Index(spec, "@"); i >= 0 {
+		ch, err := strconv.Atoi(spec[i+1:])
+		if err != nil || ch < 1 || ch > 16 {
+			return binding, fmt.Errorf("invalid channel in binding %q (use 1-16)", s)
+		}
+		binding.Channel = ch - 1
+		spec = spec[:i]
+	}
+
+	number, err := strconv.Atoi(spec)
+	if err != nil || number < 0 || number > 127 {
+		return binding, fmt.Errorf("invalid number in binding %q (use 0-127)", s)
+	}
+	binding.Number = uint8(number)
+
+	return binding, nil
+}
+
+func (self PadBinding) String() string {
+	s := fmt.Sprintf("%s:%d", bindingKindNames[self.Kind], self.Number)
+	if self.Channel != AnyChannel {
+		s += fmt.Sprintf("@%d", self.Channel+1)
+	}
+
+	return s
+}
+
+// Whether the binding matches a message, and if so whether the message presses
+// or releases the pad. Bindings without a channel match the fallback channel,
+// which can be AnyChannel.
+func (self PadBinding) Match(msg midi.Message, fallback int) (matched bool, pressed bool) {
+	var kind BindingKind
+	var ch, number, value uint8
+	switch m := msg.(type) {
+	case channel.NoteOn:
+		kind, ch, number, value = BindNote, m.Channel(), m.Key(), m.Velocity()
+	case channel.NoteOff:
+		kind, ch, number = BindNote, m.Channel(), m.Key()
+	case channel.NoteOffVelocity:
+		kind, ch, number = BindNote, m.Channel(), m.Key()
+	case channel.ControlChange:
+		// Pads sending controllers usually send 127 when pressed and 0 when released
+		kind, ch, number, value = BindControl, m.Channel(), m.Controller(), m.Value()
+		if value < 64 {
+			value = 0
+		}
+	case channel.ProgramChange:
+		kind, ch, number, value = BindProgram, m.Channel(), m.Program(), 1
+	default:
+		return false, false
+	}
+
+	bound := self.Channel
+	if bound == AnyChannel {
+		bound = fallback
+	}
+
+	if kind != self.Kind || number != self.Number || (bound != AnyChannel && int(ch) != bound) {
+		return false, false
+	}
+
+	return true, value > 0
+}
+
+// A message which presses or releases the pad, sent on its channel, the
+// fallback channel or the first channel. Program change pads have no release
+// message.
+func (self PadBinding) Message(pressed bool, fallback int) (midi.Message, bool) {
+	bound := self.Channel
+	if bound == AnyChannel {
+		bound = max(fallback, 0)
+	}
+	ch := channel.Channel(bound)
+
+	switch self.Kind {
+	case BindControl:
+		if pressed {
+			return ch.ControlChange(self.Number, 127), true
+		}
+		return ch.ControlChange(self.Number, 0), true
+	case BindProgram:
+		return ch.ProgramChange(self.Number), pressed
+	}
+
+	if pressed {
+		return ch.NoteOn(self.Number, KeyboardVelocity), true
+	}
+	return ch.NoteOff(self.Number), true
+}
+
+// The binding which would be learned from a message pressing a pad, if it is
+// one
+func LearnBinding(msg midi.Message) (PadBinding, bool) {
+	switch m := msg.(type) {
+	case channel.NoteOn:
+		if m.Velocity() > 0 {
+			return PadBinding{Kind: BindNote, Number: m.Key(), Channel: int(m.Channel())}, true
+		}
+	case channel.ControlChange:
+		if m.Value() >= 64 {
+			return PadBinding{Kind: BindControl, Number: m.Controller(), Channel: int(m.Channel())}, true
+		}
+	case channel.ProgramChange:
+		return PadBinding{Kind: BindProgram, Number: m.Program(), Channel: int(m.Channel())}, true
+	}
+
+	return PadBinding{}, false
+}
+
+// A pad on the controller and what pressing it does
+type Pad struct {
+	Binding PadBinding
+	Action  PadAction
+	// Pedals are usually plugged into the keyboard, so aren't on the pad channel
+	Pedal bool
+}
+
+func (self Pad) fallbackChannel() int {
+	if self.Pedal {
+		return AnyChannel
+	}
+
+	return PadChannel()
+}
+
+func (self Pad) Match(msg midi.Message) (matched bool, pressed bool) {
+	return self.Binding.Match(msg, self.fallbackChannel())
+}
+
+func (self Pad) Message(pressed bool) (midi.Message, bool) {
+	return self.Binding.Message(pressed, self.fallbackChannel())
+}
+
+// How a pad is written in the config file
+type padConfig struct {
+	Binding string
+	Action  string
+}
+
+// The current profile's pads, in the order they're shown
+var Pads []Pad
+
+// Sustain pedals and foot switches, which work like pads but aren't shown.
+// They're numbered after the pads.
+var Pedals []Pad
+
+// Without a list of pads in the config, there are four pads with their
+// original meanings, bound by these settings
+var legacyPadSettings = []string{"AKey", "BKey", "CKey", "DKey"}
+var legacyPadActions = []PadAction{ActionPadA, ActionPadB, ActionPadC, ActionPadD}
+
+// Pads are named by letter, or by number after the 26th
+func padName(pad SelectionKey) string {
+	if pad >= 1 && pad <= 26 {
+		return string(rune('A' + pad - 1))
+	}
+
+	return strconv.Itoa(int(pad))
+}
+
+func parsePadName(name string) (SelectionKey, bool) {
+	n, err := strconv.Atoi(name)
+	if err != nil {
+		if len(name) != 1 {
+			return KeyInvalid, false
+		}
+		n = int(unicode.ToUpper(rune(name[0]))-'A') + 1
+	}
+
+	if n < 1 || n > len(Pads)+len(Pedals) {
+		return KeyInvalid, false
+	}
+
+	return SelectionKey(n), true
+}
+
+// A pad or pedal by its number
+func getPad(pad SelectionKey) (Pad, bool) {
+	if pad < 1 || int(pad) > len(Pads)+len(Pedals) {
+		return Pad{}, false
+	}
+
+	if int(pad) > len(Pads) {
+		return Pedals[int(pad)-len(Pads)-1], true
+	}
+
+	return Pads[pad-1], true
+}
+
+// The channel the controller sends pads on, which is left out of exercise
+// input, or AnyChannel if pads share a channel with the keys
+func PadChannel() int {
+	return viper.GetInt("PadChannel") - 1
+}
+
+// Whether a message was sent on the pad channel
+func onPadChannel(msg midi.Message) bool {
+	m, ok := msg.(channel.Message)
+	return ok && PadChannel() != AnyChannel && int(m.Channel()) == PadChannel()
+}
+
+// Read the pads and the pad channel from the profile's settings
+func loadPads() error {
+	if ch := viper.GetInt("PadChannel"); ch < 0 || ch > 16 {
+		return fmt.Errorf("PadChannel: invalid channel %d (use 1-16, or 0 for none)", ch)
+	}
+
+	configs := []padConfig{}
+	if viper.IsSet("Pads") {
+		if err := viper.UnmarshalKey("Pads", &configs); err != nil {
+			return fmt.Errorf("Pads: %v", err)
+		}
+	} else {
+		for i, setting := range legacyPadSettings {
+			configs = append(configs, padConfig{Binding: viper.GetString(setting), Action: string(legacyPadActions[i])})
+		}
+	}
+
+	pads, err := parsePads(configs, "pad", false)
+	if err != nil {
+		return err
+	}
+
+	configs = []padConfig{}
+	if err := viper.UnmarshalKey("Pedals", &configs); err != nil {
+		return fmt.Errorf("Pedals: %v", err)
+	}
+
+	pedals, err := parsePads(configs, "pedal", true)
+	if err != nil {
+		return err
+	}
+
+	Pads = pads
+	Pedals = pedals
+	return nil
+}
+
+func parsePads(configs []padConfig, kind string, pedal bool) ([]Pad, error) {
+	pads := []Pad{}
+	for i, config := range configs {
+		name := strconv.Itoa(i + 1)
+		if !pedal {
+			name = padName(SelectionKey(i + 1))
+		}
+
+		binding, err := ParseBinding(config.Binding)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", kind, name, err)
+		}
+
+		action, err := ParseAction(config.Action)
+		if err != nil {
+			return nil, fmt.Errorf("%s %s: %v", kind, name, err)
+		}
+
+		pads = append(pads, Pad{Binding: binding, Action: action, Pedal: pedal})
+	}
+
+	return pads, nil
+}
+
+// Save new bindings for every pad, keeping their actions, in the same form
+// the pads were configured in
+func savePadBindings(bindings []PadBinding) error {
+	settings := map[string]interface{}{}
+	if viper.IsSet("Pads") {
+		configs := []map[string]string{}
+		for i, pad := range Pads {
+			configs = append(configs, map[string]string{"binding": bindings[i].String(), "action": string(pad.Action)})
+		}
+		settings["Pads"] = configs
+	} else {
+		for i, setting := range legacyPadSettings {
+			settings[setting] = bindings[i].String()
+		}
+	}
+
+	if err := SaveSettings(settings); err != nil {
+		return err
+	}
+
+	return loadPads()
+}
+
+// The pad or pedal a message is bound to, and whether it presses or releases
+// it
+func getSelectionKey(msg midi.Message) (SelectionKey, bool) {
+	for i, pad := range append(append([]Pad{}, Pads...), Pedals...) {
+		if matched, pressed := pad.Match(msg); matched {
+			return SelectionKey(i + 1), pressed
+		}
+	}
+
+	return KeyInvalid, false
+}
@@ -0,0 +1,807 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/spf13/viper"
+	"gitlab.com/gomidi/rtmididrv"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A subcommand run from the command line instead of the interactive app
+type Command struct {
+	Name        string
+	Usage       string
+	Description string
+	Run         func(args []string) error
+}
+
+var Commands = []Command{
+	{
+		Name:        "anki",
+		Usage:       "anki [filters] [file]",
+		Description: "write cards as a tab-separated file for importing into Anki",
+		Run:         runAnki,
+	},
+	{
+		Name:        "backup",
+		Usage:       "backup",
+		Description: "back up the database now",
+		Run:         runBackup,
+	},
+	{
+		Name:        "convert",
+		Usage:       "convert [-force] <from> <to>",
+		Description: "copy a database to another file, e.g. from bolt to SQLite (.sqlite)",
+		Run:         runConvert,
+	},
+	{
+		Name:        "cram",
+		Usage:       "cram [filters] [-limit n]",
+		Description: "practice every matching card, due or not, without changing its schedule",
+		Run:         runCram,
+	},
+	{
+		Name:        "devices",
+		Usage:       "devices",
+		Description: "list MIDI inputs and outputs, marking the ones chordy will use",
+		Run:         runDevices,
+	},
+	{
+		Name:        "doctor",
+		Usage:       "doctor [-yes]",
+		Description: "check the database for damaged cards, offering to fix or quarantine them",
+		Run:         runDoctor,
+	},
+	{
+		Name:        "export",
+		Usage:       "export [-format f] [file]",
+		Description: "write cards and review history to JSON or CSV",
+		Run:         runExport,
+	},
+	{
+		Name:        "import",
+		Usage:       "import [-merge m] <file>",
+		Description: "read cards and review history from JSON or CSV",
+		Run:         runImport,
+	},
+	{
+		Name:        "list",
+		Usage:       "list [filters]",
+		Description: "list cards with their schedule, status and tags",
+		Run:         runList,
+	},
+	{
+		Name:        "migrate",
+		Usage:       "migrate [-dry-run]",
+		Description: "upgrade the database (done automatically on startup)",
+		Run:         runMigrate,
+	},
+	{
+		Name:        "profiles",
+		Usage:       "profiles",
+		Description: "list profiles, marking the current one (use -profile to pick or create one)",
+		Run:         runProfiles,
+	},
+	{
+		Name:        "replay",
+		Usage:       "replay [-speed n] <file>",
+		Description: "play a session from a recording or a file of notes and pad presses, printing what happens",
+		Run:         runReplay,
+	},
+	{
+		Name:        "restore",
+		Usage:       "restore [backup]",
+		Description: "list backups, or replace the database with one (by number or path)",
+		Run:         runRestore,
+	},
+	{
+		Name:        "suspend",
+		Usage:       "suspend [filters]",
+		Description: "stop matching cards from appearing in sessions, keeping their progress",
+		Run:         func(args []string) error { return runUpdateCards("suspend", args, suspendCard) },
+	},
+	{
+		Name:        "unsuspend",
+		Usage:       "unsuspend [filters]",
+		Description: "return suspended or buried cards to sessions",
+		Run:         func(args []string) error { return runUpdateCards("unsuspend", args, unsuspendCard) },
+	},
+	{
+		Name:        "sync",
+		Usage:       "sync <dir>",
+		Description: "merge progress with a store in a shared directory, e.g. a USB stick",
+		Run:         runSync,
+	},
+	{
+		Name:        "tag",
+		Usage:       "tag [filters] <tag>",
+		Description: "add a tag to matching cards",
+		Run:         func(args []string) error { return runTag("tag", args, (*Card).AddTag) },
+	},
+	{
+		Name:        "untag",
+		Usage:       "untag [filters] <tag>",
+		Description: "remove a tag from matching cards",
+		Run:         func(args []string) error { return runTag("untag", args, (*Card).RemoveTag) },
+	},
+}
+
+func FindCommand(name string) (Command, bool) {
+	for _, command := range Commands {
+		if command.Name == name {
+			return command, true
+		}
+	}
+
+	return Command{}, false
+}
+
+func PrintUsage() {
+	fmt.Fprintln(os.Stderr, "usage: chordy [-profile name] [-input device] [-output device] [command]")
+	fmt.Fprintln(os.Stderr, "\nWithout a command, starts a practice session. Commands:")
+	for _, command := range Commands {
+		fmt.Fprintf(os.Stderr, "  %-28s %s\n", command.Usage, command.Description)
+	}
+	fmt.Fprintln(os.Stderr, "\nFilters: -type, -root, -form, -tag, -match, -failed-days (see chordy <command> -h)")
+}
+
+// Open the configured database for the duration of a command
+func withDB(f func(db Store) error) error {
+	db, err := Connect(viper.GetString("DatabasePath"))
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	return f(db)
+}
+
+func newFlagSet(name string) *flag.FlagSet {
+	return flag.NewFlagSet("chordy "+name, flag.ContinueOnError)
+}
+
+func runProfiles(args []string) error {
+	if err := newFlagSet("profiles").Parse(args); err != nil {
+		return err
+	}
+
+	profiles, err := ListProfiles()
+	if err != nil {
+		return err
+	}
+
+	for _, profile := range profiles {
+		marker := " "
+		if profile == CurrentProfile {
+			marker = "*"
+		}
+		fmt.Printf("%s %s\n", marker, profile)
+	}
+
+	return nil
+}
+
+func runList(args []string) error {
+	var filter CardFilter
+	flags := newFlagSet("list")
+	filter.AddFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withDB(func(db Store) error {
+		if err := filter.Load(db); err != nil {
+			return err
+		}
+
+		cards, err := db.AllCards()
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		for _, card := range cards {
+			if !filter.Matches(card) {
+				continue
+			}
+
+			var status string
+			if card.Suspended {
+				status = "suspended"
+			} else if !card.IsAvailable(now) {
+				status = fmt.Sprintf("buried until %s", card.BuriedUntil.Format("2006-01-02 15:04"))
+			} else if card.LastRecalledAt.IsZero() {
+				status = "new"
+			} else {
+				status = fmt.Sprintf("due %s", NextRecallTime(card).Format("2006-01-02"))
+			}
+
+			fmt.Printf("%-24s %-26s %s\n", card.DisplayName(), status, strings.Join(card.Tags, ","))
+		}
+
+		return nil
+	})
+}
+
+func runAnki(args []string) error {
+	var filter CardFilter
+	flags := newFlagSet("anki")
+	filter.AddFlags(flags)
+	scheduling := flags.Bool("scheduling", false, "add interval, ease and due date fields")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withDB(func(db Store) error {
+		if err := filter.Load(db); err != nil {
+			return err
+		}
+
+		cards, err := db.AllCards()
+		if err != nil {
+			return err
+		}
+
+		selected := []Card{}
+		for _, card := range cards {
+			if filter.Matches(card) {
+				selected = append(selected, card)
+			}
+		}
+
+		return writeOutput(flags.Arg(0), func(w io.Writer) error { return WriteAnkiTSV(w, selected, *scheduling) })
+	})
+}
+
+func runBackup(args []string) error {
+	flags := newFlagSet("backup")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return withDB(func(db Store) error {
+		path, err := BackupStore(db, viper.GetString("DatabasePath"), viper.GetInt("BackupCount"))
+		if err != nil {
+			return err
+		}
+
+		fmt.Println(path)
+		return nil
+	})
+}
+
+// Read a replay from a text file, or a MIDI file recorded during a session
+func readReplayFile(path string) ([]ReplayEvent, error) {
+	if isSMFPath(path) {
+		return ReadReplaySMF(path)
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ReadReplay(f)
+}
+
+func runReplay(args []string) error {
+	session := SessionOptions{Reschedule: true}
+
+	flags := newFlagSet("replay")
+	speed := flags.Float64("speed", 0, "multiple of real time to replay at (0 for as fast as possible)")
+	seed := flags.Int64("seed", 1, "seed for shuffling cards, so replays pick the same cards")
+	write := flags.Bool("write", false, "grade cards in the database instead of a scratch copy")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: chordy replay [-speed n] [-seed n] [-write] <file>")
+	}
+
+	events, err := readReplayFile(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	// Replays aren't practice, so don't record them again
+	viper.Set("RecordSessions", false)
+
+	if !*write {
+		scratch, err := ioutil.TempDir("", "chordy-replay")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(scratch)
+
+		dbPath := viper.GetString("DatabasePath")
+		scratchPath := filepath.Join(scratch, filepath.Base(dbPath))
+		if err := withDB(func(db Store) error { return db.Backup(scratchPath) }); err != nil {
+			return err
+		}
+
+		viper.Set("DatabasePath", scratchPath)
+	}
+
+	rand.Seed(*seed)
+	return RunHeadless(session, NewReplaySource(events, *speed), os.Stdout)
+}
+
+func runRestore(args []string) error {
+	flags := newFlagSet("restore")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	dbPath := viper.GetString("DatabasePath")
+	backups, err := ListBackups(dbPath)
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		if len(backups) == 0 {
+			fmt.Printf("no backups in %s\n", BackupDir(dbPath))
+		}
+
+		for i, backup := range backups {
+			fmt.Printf("%3d  %s\n", i+1, backup)
+		}
+
+		return nil
+	}
+
+	backup := flags.Arg(0)
+	if n, err := strconv.Atoi(backup); err == nil {
+		if n < 1 || n > len(backups) {
+			return fmt.Errorf("no backup number %d (run chordy restore to list them)", n)
+		}
+		backup = backups[n-1]
+	}
+
+	if err := RestoreBackup(backup, dbPath); err != nil {
+		return err
+	}
+
+	fmt.Printf("restored %s\n", backup)
+	return nil
+}
+
+func runDevices(args []string) error {
+	if err := newFlagSet("devices").Parse(args); err != nil {
+		return err
+	}
+
+	driver, err := rtmididrv.New()
+	if err != nil {
+		return err
+	}
+	defer driver.Close()
+
+	ins, err := driver.Ins()
+	if err != nil {
+		return err
+	}
+
+	outs, err := driver.Outs()
+	if err != nil {
+		return err
+	}
+
+	selected, selectErr := selectPort(inputPorts(ins), viper.GetString("MidiInput"), "input")
+
+	fmt.Println("Inputs:")
+	for i, in := range ins {
+		marker := " "
+		if selectErr == nil && i == selected {
+			marker = "*"
+		}
+		fmt.Printf("%s %d: %s\n", marker, i, in.String())
+	}
+
+	// Without an output, notes are played on the built-in synth
+	outputSelector := viper.GetString("MidiOutput")
+	selectedOut, selectOutErr := selectPort(outputPorts(outs), outputSelector, "output")
+
+	fmt.Println("Outputs:")
+	for i, out := range outs {
+		marker := " "
+		if outputSelector != "" && selectOutErr == nil && i == selectedOut {
+			marker = "*"
+		}
+		fmt.Printf("%s %d: %s\n", marker, i, out.String())
+	}
+
+	if outputSelector == "" {
+		fmt.Println("  (notes are played on the built-in synth)")
+	}
+
+	if selectErr != nil {
+		fmt.Printf("\n%v\n", selectErr)
+	}
+
+	if outputSelector != "" && selectOutErr != nil {
+		fmt.Printf("\n%v\n", selectOutErr)
+	}
+
+	return nil
+}
+
+func runDoctor(args []string) error {
+	flags := newFlagSet("doctor")
+	yes := flags.Bool("yes", false, "repair without asking")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	// Migrations read every card, so they would fail on the cards being looked for
+	db, err := ConnectWithoutMigrating(viper.GetString("DatabasePath"))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	report, err := CheckCards(db, time.Now())
+	if err != nil {
+		return err
+	}
+
+	fmt.Print(report.String())
+	if len(report.Found) == 0 {
+		return nil
+	}
+
+	if !*yes && !confirm("Repair these cards?") {
+		return nil
+	}
+
+	if _, err := BackupStore(db, viper.GetString("DatabasePath"), viper.GetInt("BackupCount")); err != nil {
+		return fmt.Errorf("could not back up before repairing: %v", err)
+	}
+
+	if err := RepairCards(db, &report); err != nil {
+		return err
+	}
+
+	fmt.Printf("fixed %d cards, quarantined %d cards\n", report.Fixed, report.Quarantined)
+	return nil
+}
+
+// Ask a yes/no question on the terminal, defaulting to no
+func confirm(question string) bool {
+	fmt.Printf("%s [y/N] ", question)
+
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+func runConvert(args []string) error {
+	flags := newFlagSet("convert")
+	force := flags.Bool("force", false, "replace the cards of an existing target database, backing it up first")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("usage: chordy convert [-force] <from> <to>")
+	}
+
+	report, err := ConvertDatabase(flags.Arg(0), flags.Arg(1), *force)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("copied %d cards and %d reviews\n", report.Added+report.Updated, report.ReviewsAdded)
+	return nil
+}
+
+func runSync(args []string) error {
+	flags := newFlagSet("sync")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: chordy sync <dir>")
+	}
+
+	// A directory holds one store per profile, but a store file can be given directly
+	remotePath := flags.Arg(0)
+	if info, err := os.Stat(remotePath); err == nil && info.IsDir() {
+		remotePath = filepath.Join(remotePath, SyncStoreName(CurrentProfile))
+	}
+
+	return withDB(func(db Store) error {
+		if _, err := BackupStore(db, viper.GetString("DatabasePath"), viper.GetInt("BackupCount")); err != nil {
+			return fmt.Errorf("could not back up before syncing: %v", err)
+		}
+
+		remote, err := Connect(remotePath)
+		if err != nil {
+			return err
+		}
+		defer remote.Close()
+
+		report, err := Sync(db, remote)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("pulled %d reviews, pushed %d reviews, updated %d cards\n", report.ReviewsPulled, report.ReviewsPushed, report.CardsChanged)
+		return nil
+	})
+}
+
+func runCram(args []string) error {
+	var filter CardFilter
+	session := SessionOptions{Filter: &filter}
+
+	flags := newFlagSet("cram")
+	filter.AddFlags(flags)
+	flags.IntVar(&session.Limit, "limit", 0, "maximum number of cards in the session (0 for all)")
+	flags.BoolVar(&session.Reschedule, "reschedule", false, "grade cards as in a normal session, updating their schedule")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	return RunApp(session)
+}
+
+// Use the format given on the command line, or guess it from the file name
+func exportFormat(format, path string) (string, error) {
+	if format == "" {
+		if strings.ToLower(filepath.Ext(path)) == ".csv" {
+			return "csv", nil
+		}
+		return "json", nil
+	}
+
+	if format != "json" && format != "csv" {
+		return "", fmt.Errorf("unknown format %q (expected json or csv)", format)
+	}
+
+	return format, nil
+}
+
+// JSON files hold the review history along with the cards
+var errReviewsWithJSON = errors.New("-reviews only applies to csv, as json files already include the review history")
+
+// Write to a file, or to stdout if the path is empty or "-"
+func writeOutput(path string, write func(io.Writer) error) error {
+	if path == "" || path == "-" {
+		return write(os.Stdout)
+	}
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if err := write(out); err != nil {
+		out.Close()
+		return err
+	}
+
+	return out.Close()
+}
+
+func runExport(args []string) error {
+	flags := newFlagSet("export")
+	format := flags.String("format", "", "json or csv (default: from the file name, or json)")
+	reviewsPath := flags.String("reviews", "", "with csv, also write the review history to this file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	path := flags.Arg(0)
+	f, err := exportFormat(*format, path)
+	if err != nil {
+		return err
+	}
+
+	if f == "json" && *reviewsPath != "" {
+		return errReviewsWithJSON
+	}
+
+	return withDB(func(db Store) error {
+		data, err := ExportStore(db)
+		if err != nil {
+			return err
+		}
+
+		if f == "json" {
+			return writeOutput(path, func(w io.Writer) error { return WriteExportJSON(w, data) })
+		}
+
+		if err := writeOutput(path, func(w io.Writer) error { return WriteCardsCSV(w, data.Cards) }); err != nil {
+			return err
+		}
+
+		if *reviewsPath == "" {
+			return nil
+		}
+
+		return writeOutput(*reviewsPath, func(w io.Writer) error { return WriteReviewsCSV(w, data.Reviews) })
+	})
+}
+
+func runImport(args []string) error {
+	flags := newFlagSet("import")
+	format := flags.String("format", "", "json or csv (default: from the file name, or json)")
+	merge := flags.String("merge", string(MergeNewer), "how to treat existing cards: newer, overwrite or skip")
+	reviewsPath := flags.String("reviews", "", "with csv, also read the review history from this file")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("usage: chordy import [-format f] [-merge m] [-reviews file] <file>")
+	}
+
+	path := flags.Arg(0)
+	f, err := exportFormat(*format, path)
+	if err != nil {
+		return err
+	}
+
+	if f == "json" && *reviewsPath != "" {
+		return errReviewsWithJSON
+	}
+
+	mode, err := ParseMergeMode(*merge)
+	if err != nil {
+		return err
+	}
+
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	var data Export
+	if f == "json" {
+		data, err = ReadExportJSON(in)
+	} else {
+		data.Cards, err = ReadCardsCSV(in)
+	}
+	if err != nil {
+		return fmt.Errorf("could not read %s: %v", path, err)
+	}
+
+	if *reviewsPath != "" {
+		reviewsIn, err := os.Open(*reviewsPath)
+		if err != nil {
+			return err
+		}
+		defer reviewsIn.Close()
+
+		data.Reviews, err = ReadReviewsCSV(reviewsIn)
+		if err != nil {
+			return fmt.Errorf("could not read %s: %v", *reviewsPath, err)
+		}
+	}
+
+	return withDB(func(db Store) error {
+		report, err := db.Import(data, mode)
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("cards: %d added, %d updated, %d skipped\n", report.Added, report.Updated, report.Skipped)
+		fmt.Printf("reviews: %d added, %d already present\n", report.ReviewsAdded, report.ReviewsExisting)
+		return nil
+	})
+}
+
+func runMigrate(args []string) error {
+	flags := newFlagSet("migrate")
+	dryRun := flags.Bool("dry-run", false, "report pending migrations without applying them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	db, err := ConnectWithoutMigrating(viper.GetString("DatabasePath"))
+	if err != nil {
+		return err
+	}
+
+	defer db.Close()
+
+	report, err := db.Migrate(*dryRun)
+	if err != nil {
+		return err
+	}
+
+	if *dryRun {
+		fmt.Print("dry run: ")
+	}
+	fmt.Print(report.String())
+	return nil
+}
+
+func suspendCard(card *Card) {
+	card.Suspended = true
+}
+
+func unsuspendCard(card *Card) {
+	card.Suspended = false
+	card.BuriedUntil = time.Time{}
+}
+
+func runUpdateCards(name string, args []string, update func(*Card)) error {
+	var filter CardFilter
+	flags := newFlagSet(name)
+	filter.AddFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if filter.IsEmpty() {
+		return errors.New("no filter given; use -type, -root, -form, -tag, -match or -failed-days to select cards")
+	}
+
+	return withDB(func(db Store) error {
+		if err := filter.Load(db); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		n, err := db.UpdateCards(filter.Matches, func(card *Card) {
+			update(card)
+			card.ChangedAt = now
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%v: %d cards\n", name, n)
+		return nil
+	})
+}
+
+func runTag(name string, args []string, update func(*Card, string)) error {
+	var filter CardFilter
+	flags := newFlagSet(name)
+	filter.AddFlags(flags)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: chordy %s [filters] <tag>", name)
+	}
+
+	tag := flags.Arg(0)
+
+	return withDB(func(db Store) error {
+		if err := filter.Load(db); err != nil {
+			return err
+		}
+
+		now := time.Now()
+		n, err := db.UpdateCards(filter.Matches, func(card *Card) {
+			update(card, tag)
+			card.ChangedAt = now
+		})
+		if err != nil {
+			return err
+		}
+
+		fmt.Printf("%v %q: %d cards\n", name, tag, n)
+		return nil
+	})
+}
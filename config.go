@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+)
+
+// The default profile keeps its config and data at the top level of chordy's
+// directories, where they were before profiles existed. Other profiles live in
+// a "profiles" subdirectory of each.
+const DefaultProfile = "default"
+
+// Name of the profile whose settings are loaded into viper
+var CurrentProfile = DefaultProfile
+
+// Settings given on the command line, which apply to whichever profile is loaded
+var settingOverrides = map[string]interface{}{}
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+func configRoot() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find user home directory: %v", err)
+	}
+
+	return filepath.Join(home, "/.config/chordy"), nil
+}
+
+func dataRoot() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("could not find user home directory: %v", err)
+	}
+
+	return filepath.Join(home, "/.data/chordy"), nil
+}
+
+// Directories holding a profile's config file and its default database
+func profileDirs(name string) (string, string, error) {
+	configDir, err := configRoot()
+	if err != nil {
+		return "", "", err
+	}
+
+	dataDir, err := dataRoot()
+	if err != nil {
+		return "", "", err
+	}
+
+	if name == DefaultProfile {
+		return configDir, dataDir, nil
+	}
+
+	return filepath.Join(configDir, "profiles", name), filepath.Join(dataDir, "profiles", name), nil
+}
+
+func setConfigDefaults(dataDir string) {
+	viper.SetDefault("DatabasePath", filepath.Join(dataDir, "db"))
+	viper.SetDefault("AKey", "40")
+	viper.SetDefault("BKey", "41")
+	viper.SetDefault("CKey", "42")
+	viper.SetDefault("DKey", "43")
+	viper.SetDefault("PadChannel", 0)
+	viper.SetDefault("DailyGoal", 10)
+	viper.SetDefault("Language", "en")
+	viper.SetDefault("BackupCount", 10)
+	viper.SetDefault("MaxItemsPerDay", MaxItemsPerDay)
+	viper.SetDefault("Decks", ExerciseTypes)
+	viper.SetDefault("MidiInput", "")
+	viper.SetDefault("MidiOutput", "")
+	viper.SetDefault("RecordSessions", true)
+}
+
+// Load a profile's settings, creating the profile with default settings if it
+// doesn't exist yet
+func LoadProfile(name string) error {
+	if !profileNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid profile name %q (use letters, numbers, - and _)", name)
+	}
+
+	configDir, dataDir, err := profileDirs(name)
+	if err != nil {
+		return err
+	}
+
+	if err = os.MkdirAll(configDir, os.ModePerm); err != nil {
+		return fmt.Errorf("could not create config directory: %v", err)
+	}
+
+	if err = os.MkdirAll(dataDir, os.ModePerm); err != nil {
+		return fmt.Errorf("could not create data directory: %v", err)
+	}
+
+	viper.Reset()
+	setConfigDefaults(dataDir)
+
+	configPath := filepath.Join(configDir, "config.json")
+	viper.SetConfigFile(configPath)
+
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		if err = viper.WriteConfigAs(configPath); err != nil {
+			return fmt.Errorf("could not write default config file: %v", err)
+		}
+	} else if err := viper.ReadInConfig(); err != nil {
+		return fmt.Errorf("could not read config file: %v", err)
+	}
+
+	for key, value := range settingOverrides {
+		viper.Set(key, value)
+	}
+
+	if err := loadPads(); err != nil {
+		return fmt.Errorf("invalid config file %s: %v", configPath, err)
+	}
+
+	CurrentProfile = name
+	return nil
+}
+
+// Override a setting for every profile loaded from now on, without saving it
+func OverrideSetting(key string, value interface{}) {
+	settingOverrides[key] = value
+	viper.Set(key, value)
+}
+
+// Change settings in the current profile's config file, leaving the rest of
+// the file alone. Settings overridden on the command line aren't saved.
+func SaveSettings(settings map[string]interface{}) error {
+	file := viper.New()
+	file.SetConfigFile(viper.ConfigFileUsed())
+	if err := file.ReadInConfig(); err != nil {
+		return err
+	}
+
+	for key, value := range settings {
+		file.Set(key, value)
+	}
+
+	if err := file.WriteConfig(); err != nil {
+		return err
+	}
+
+	for key, value := range settings {
+		viper.Set(key, value)
+	}
+
+	return nil
+}
+
+// Names of all profiles, with the default profile first
+func ListProfiles() ([]string, error) {
+	configDir, err := configRoot()
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ioutil.ReadDir(filepath.Join(configDir, "profiles"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	profiles := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() && profileNamePattern.MatchString(entry.Name()) && entry.Name() != DefaultProfile {
+			profiles = append(profiles, entry.Name())
+		}
+	}
+
+	sort.Strings(profiles)
+	return append([]string{DefaultProfile}, profiles...), nil
+}
+
+// Exercise types which are included in sessions
+func EnabledDecks() []string {
+	return viper.GetStringSlice("Decks")
+}
+
+func isDeckEnabled(exerciseType string) bool {
+	for _, deck := range EnabledDecks() {
+		if deck == exerciseType {
+			return true
+		}
+	}
+
+	return false
+}
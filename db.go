@@ -4,17 +4,19 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/boltdb/bolt"
-	"math/rand"
 	"sort"
+	"strings"
 	"time"
 )
 
 var CardBucket = []byte("cards")
 var MigrationBucket = []byte("migrations")
-
-var Migrated = []byte{1}
+var SettingBucket = []byte("settings")
+var QuarantineBucket = []byte("quarantine")
 
 type Card struct {
+	// Stable identifier, which stays the same if the display name changes
+	ID                 string
 	Name               string
 	Recalls            uint
 	Ef                 float32
@@ -22,10 +24,76 @@ type Card struct {
 	ExerciseType       string
 	ExerciseDefinition string
 	LastRecalledAt     time.Time
+
+	// Suspended cards are never picked for a session, but keep their progress
+	Suspended bool
+	// Buried cards are skipped until this time has passed
+	BuriedUntil time.Time
+	Tags        []string
+	// When the card was last suspended, buried or tagged (or the reverse), so
+	// syncing can keep the latest change
+	ChangedAt time.Time
 }
 
 func (self *Card) Key() []byte {
-	return []byte(self.Name)
+	return []byte(self.ID)
+}
+
+// Build a card's ID from its exercise, e.g. "chord:Ebmaj"
+func CardID(exerciseType, exerciseDefinition string) string {
+	return exerciseType + ":" + strings.Join(strings.Fields(exerciseDefinition), " ")
+}
+
+// Whether the card can be picked for a session at the given time
+func (self *Card) IsAvailable(now time.Time) bool {
+	return !self.Suspended && !self.BuriedUntil.After(now)
+}
+
+// Hide the card until the start of the next day
+func (self *Card) Bury(now time.Time) {
+	y, m, d := now.Date()
+	self.BuriedUntil = time.Date(y, m, d+1, 0, 0, 0, 0, now.Location())
+}
+
+func (self *Card) HasTag(tag string) bool {
+	for _, t := range self.Tags {
+		if t == tag {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (self *Card) AddTag(tag string) {
+	if !self.HasTag(tag) {
+		self.Tags = append(self.Tags, tag)
+		sort.Strings(self.Tags)
+	}
+}
+
+func (self *Card) RemoveTag(tag string) {
+	tags := []string{}
+	for _, t := range self.Tags {
+		if t != tag {
+			tags = append(tags, t)
+		}
+	}
+	self.Tags = tags
+}
+
+// Split an exercise definition like "Eb maj" or "C#min" into its root note and form
+func SplitDefinition(definition string) (string, string) {
+	if definition == "" {
+		return "", ""
+	}
+
+	rootLength := 1
+	if len(definition) > 1 && (definition[1] == '#' || definition[1] == 'b') {
+		rootLength = 2
+	}
+
+	return definition[:rootLength], strings.TrimSpace(definition[rootLength:])
 }
 
 func (self *Card) Serialize() ([]byte, error) {
@@ -38,75 +106,192 @@ func DeserializeCard(data []byte) (Card, error) {
 	return card, err
 }
 
-type DB struct {
+// Store implementation backed by a bolt database file
+type BoltStore struct {
 	db *bolt.DB
 }
 
-func Connect(path string) (*DB, error) {
-	db, err := bolt.Open(path, 0600, nil)
+// Open a bolt database, applying any pending migrations
+func ConnectBolt(path string) (*BoltStore, error) {
+	db, err := ConnectBoltWithoutMigrating(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := db.Migrate(false); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// Open a bolt database and create its buckets, leaving pending migrations unapplied
+func ConnectBoltWithoutMigrating(path string) (*BoltStore, error) {
+	// Bolt locks the file while it's open, so don't wait forever for another
+	// chordy process to finish with it
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: time.Second})
+	if err == bolt.ErrTimeout {
+		return nil, fmt.Errorf("%s is in use by another chordy process", path)
+	}
 	if err != nil {
 		return nil, err
 	}
 
 	err = db.Update(func(tx *bolt.Tx) error {
-		cards, err := tx.CreateBucketIfNotExists(CardBucket)
-		if err != nil {
-			return err
+		for _, bucket := range [][]byte{CardBucket, ReviewBucket, MigrationBucket, SettingBucket, DueBucket, QuarantineBucket} {
+			if _, err := tx.CreateBucketIfNotExists(bucket); err != nil {
+				return err
+			}
 		}
 
-		migrations, err := tx.CreateBucketIfNotExists(MigrationBucket)
+		return nil
+	})
+
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+func (self *BoltStore) Close() {
+	self.db.Close()
+}
+
+func (self *BoltStore) Upsert(card Card) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		return putCard(tx, card)
+	})
+}
+
+func (self *BoltStore) DeleteCard(key string) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		return deleteCard(tx, []byte(key))
+	})
+}
+
+// Apply an update to every card matching the filter in a single transaction,
+// returning the number of cards changed
+func (self *BoltStore) UpdateCards(filter func(Card) bool, update func(*Card)) (int, error) {
+	updated := 0
+
+	err := self.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(CardBucket)
+
+		changed := []Card{}
+		err := b.ForEach(func(k, v []byte) error {
+			card, err := DeserializeCard(v)
+			if err != nil {
+				return err
+			}
+
+			if filter(card) {
+				update(&card)
+				changed = append(changed, card)
+			}
+
+			return nil
+		})
 		if err != nil {
 			return err
 		}
 
-		hasAddedDefaults := migrations.Get([]byte("defaults"))
+		for _, card := range changed {
+			if err := putCard(tx, card); err != nil {
+				return err
+			}
+		}
 
-		if hasAddedDefaults == nil {
-			for _, card := range DefaultCards() {
-				v, err := card.Serialize()
-				if err != nil {
-					return err
-				}
+		updated = len(changed)
+		return nil
+	})
 
-				err = cards.Put(card.Key(), v)
-				if err != nil {
-					return err
-				}
-			}
+	return updated, err
+}
+
+func (self *BoltStore) AllCards() ([]Card, error) {
+	cards := []Card{}
 
-			err := migrations.Put([]byte("defaults"), Migrated)
+	err := self.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(CardBucket).ForEach(func(k, v []byte) error {
+			card, err := DeserializeCard(v)
 			if err != nil {
 				return err
 			}
-		}
 
-		return nil
+			cards = append(cards, card)
+			return nil
+		})
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	return &DB{db: db}, nil
+	sort.Slice(cards, func(a, b int) bool {
+		return cards[a].DisplayName() < cards[b].DisplayName()
+	})
+
+	return cards, nil
 }
 
-func (self *DB) Close() {
-	self.db.Close()
+func (self *BoltStore) ScanCards(f func(key string, card Card, err error) error) error {
+	return self.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(CardBucket).ForEach(func(k, v []byte) error {
+			card, err := DeserializeCard(v)
+			return f(string(k), card, err)
+		})
+	})
 }
 
-func (self *DB) Upsert(card Card) error {
+// Quarantined cards are kept as they were stored, so they can be recovered by hand
+func (self *BoltStore) QuarantineCard(key string) error {
 	return self.db.Update(func(tx *bolt.Tx) error {
-		b := tx.Bucket(CardBucket)
+		v := tx.Bucket(CardBucket).Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("no card with key %q", key)
+		}
 
-		v, err := card.Serialize()
-		if err != nil {
+		if err := tx.Bucket(QuarantineBucket).Put([]byte(key), v); err != nil {
 			return err
 		}
 
-		return b.Put(card.Key(), v)
+		return deleteCard(tx, []byte(key))
 	})
 }
 
+func (self *BoltStore) Setting(key string) (string, error) {
+	var value string
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		value = string(tx.Bucket(SettingBucket).Get([]byte(key)))
+		return nil
+	})
+
+	return value, err
+}
+
+func (self *BoltStore) SetSetting(key, value string) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(SettingBucket).Put([]byte(key), []byte(value))
+	})
+}
+
+func (self *BoltStore) Settings() (map[string]string, error) {
+	settings := map[string]string{}
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(SettingBucket).ForEach(func(k, v []byte) error {
+			settings[string(k)] = string(v)
+			return nil
+		})
+	})
+
+	return settings, err
+}
+
 func min(a, b int) int {
 	if a < b {
 		return a
@@ -115,52 +300,39 @@ func min(a, b int) int {
 	}
 }
 
-func (self *DB) GetCardsForToday() ([]Card, error) {
-	// Read in all cards which have a next recall time before now (ready for review)
+func max(a, b int) int {
+	if a > b {
+		return a
+	} else {
+		return b
+	}
+}
+
+// Read in all available cards which have a next recall time before now (ready for review),
+// in ascending order of next recall time (oldest first)
+func (self *BoltStore) DueCards(now time.Time) ([]Card, error) {
 	eligibleCards := []Card{}
-	now := time.Now()
 
 	err := self.db.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket(CardBucket)
-		b.ForEach(func(k, v []byte) error {
-			card, err := DeserializeCard(v)
-
-			if err != nil {
-				return err
-			}
-
-			if NextRecallTime(card).Before(now) {
+		return forEachDue(tx, now, func(card Card) error {
+			if card.IsAvailable(now) && NextRecallTime(card).Before(now) {
 				eligibleCards = append(eligibleCards, card)
 			}
 
 			return nil
 		})
-		return nil
 	})
 
 	if err != nil {
 		return nil, err
 	}
 
-	// Sort cards in ascending order of next recall time (oldest first)
-	sort.Slice(eligibleCards, func(a, b int) bool {
-		return NextRecallTime(eligibleCards[a]).Before(NextRecallTime(eligibleCards[b]))
-	})
-
-	// Take subset of cards for this session
-	eligibleCards = eligibleCards[:min(MaxItemsPerDay, len(eligibleCards))]
-
-	// Shuffle cards
-	rand.Seed(time.Now().UnixNano())
-	rand.Shuffle(len(eligibleCards), func(a, b int) {
-		eligibleCards[a], eligibleCards[b] = eligibleCards[b], eligibleCards[a]
-	})
-
 	return eligibleCards, nil
 }
 
 func makeDefaultCard(name, exerciseType, exerciseDefinition string) Card {
 	return Card{
+		ID:                 CardID(exerciseType, exerciseDefinition),
 		Name:               name,
 		Recalls:            0,
 		Ef:                 2.5,
@@ -172,6 +344,7 @@ func makeDefaultCard(name, exerciseType, exerciseDefinition string) Card {
 
 func makeDefaultCardWithChord(note, chordForm string) Card {
 	return Card{
+		ID:                 CardID("chord", note+chordForm),
 		Name:               fmt.Sprintf("%s%s (chord)", note, chordForm),
 		Recalls:            0,
 		Ef:                 2.5,
@@ -183,6 +356,7 @@ func makeDefaultCardWithChord(note, chordForm string) Card {
 
 func makeDefaultCardWithScale(note, scaleForm string) Card {
 	return Card{
+		ID:                 CardID("scale", note+" "+scaleForm),
 		Name:               fmt.Sprintf("%s %s (scale)", note, scaleForm),
 		Recalls:            0,
 		Ef:                 2.5,
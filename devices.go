@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"gitlab.com/gomidi/midi"
+	"gitlab.com/gomidi/rtmididrv"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Ports with this in their name are software loopbacks rather than devices,
+// so they aren't picked by default
+const throughPortName = "through"
+
+// Pick a port by index or by a case-insensitive substring of its name. An
+// empty selector picks the first port which isn't a loopback.
+func selectPort(ports []midi.Port, selector, kind string) (int, error) {
+	if len(ports) == 0 {
+		return 0, fmt.Errorf("no MIDI %s devices found", kind)
+	}
+
+	if selector == "" {
+		for i, port := range ports {
+			if !strings.Contains(strings.ToLower(port.String()), throughPortName) {
+				return i, nil
+			}
+		}
+
+		return 0, fmt.Errorf("no MIDI %s devices found, only loopback ports:\n%s", kind, describePorts(ports))
+	}
+
+	if index, err := strconv.Atoi(selector); err == nil {
+		if index < 0 || index >= len(ports) {
+			return 0, fmt.Errorf("no MIDI %s with index %d, available %ss are:\n%s", kind, index, kind, describePorts(ports))
+		}
+
+		return index, nil
+	}
+
+	matches := []int{}
+	for i, port := range ports {
+		if strings.EqualFold(port.String(), selector) {
+			return i, nil
+		}
+
+		if strings.Contains(strings.ToLower(port.String()), strings.ToLower(selector)) {
+			matches = append(matches, i)
+		}
+	}
+
+	if len(matches) == 0 {
+		return 0, fmt.Errorf("no MIDI %s matching %q, available %ss are:\n%s", kind, selector, kind, describePorts(ports))
+	}
+
+	if len(matches) > 1 {
+		return 0, fmt.Errorf("several MIDI %ss match %q, use a longer name or an index:\n%s", kind, selector, describePorts(ports))
+	}
+
+	return matches[0], nil
+}
+
+func describePorts(ports []midi.Port) string {
+	s := ""
+	for i, port := range ports {
+		s += fmt.Sprintf("  %d: %s\n", i, port.String())
+	}
+
+	return strings.TrimSuffix(s, "\n")
+}
+
+func inputPorts(ins []midi.In) []midi.Port {
+	ports := []midi.Port{}
+	for _, in := range ins {
+		ports = append(ports, in)
+	}
+
+	return ports
+}
+
+func outputPorts(outs []midi.Out) []midi.Port {
+	ports := []midi.Port{}
+	for _, out := range outs {
+		ports = append(ports, out)
+	}
+
+	return ports
+}
+
+// Find the input chosen by the MidiInput setting
+func SelectInput(ins []midi.In, selector string) (midi.In, error) {
+	i, err := selectPort(inputPorts(ins), selector, "input")
+	if err != nil {
+		return nil, err
+	}
+
+	return ins[i], nil
+}
+
+// Find and open the configured MIDI input. If no device was configured and
+// none is plugged in, there's no input (nil) and no error.
+func openInput(driver *rtmididrv.Driver) (midi.In, error) {
+	selector := viper.GetString("MidiInput")
+
+	// Machines without MIDI support at all fail to list ports
+	ins, err := driver.Ins()
+	if err != nil {
+		if selector == "" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	input, err := SelectInput(ins, selector)
+	if err != nil {
+		if selector == "" {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	if err := input.Open(); err != nil {
+		return nil, fmt.Errorf("could not open MIDI input %s: %v", input.String(), err)
+	}
+
+	return input, nil
+}
+
+// Find the output chosen by the MidiOutput setting
+func SelectOutput(outs []midi.Out, selector string) (midi.Out, error) {
+	i, err := selectPort(outputPorts(outs), selector, "output")
+	if err != nil {
+		return nil, err
+	}
+
+	return outs[i], nil
+}
+
+// Find and open a MIDI output to play notes on. Unlike inputs, outputs are
+// only used when one was chosen, so not finding it is always an error.
+func openOutput(driver *rtmididrv.Driver, selector string) (midi.Out, error) {
+	outs, err := driver.Outs()
+	if err != nil {
+		return nil, err
+	}
+
+	output, err := SelectOutput(outs, selector)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := output.Open(); err != nil {
+		return nil, fmt.Errorf("could not open MIDI output %s: %v", output.String(), err)
+	}
+
+	return output, nil
+}
+
+// How often to check whether the input device has been unplugged or plugged back in
+const InputPollInterval = time.Second
+
+// ALSA port names end with a client and port number, e.g. "MPK mini 3 MIDI 1 20:0",
+// and the client number can change when a device is plugged back in
+var alsaAddressPattern = regexp.MustCompile(` \d+:\d+$`)
+
+func portBaseName(name string) string {
+	return alsaAddressPattern.ReplaceAllString(name, "")
+}
+
+func findPortByName(ins []midi.In, name string) midi.In {
+	for _, in := range ins {
+		if portBaseName(in.String()) == portBaseName(name) {
+			return in
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+// The SM-2 algorithm never lowers a card's ease factor below this
+const MinEf = 1.3
+
+// Each perfect recall raises the ease factor by 0.1, and the interval grows
+// so quickly that no card is reviewed often enough to get past this
+const MaxEf = 5.0
+
+// A card with something wrong with it, found by CheckCards
+type CardProblem struct {
+	Key      string
+	Problems []string
+	// The card with its problems fixed, or nil if it can only be quarantined
+	Fixed *Card
+}
+
+type DoctorReport struct {
+	Checked     int
+	Found       []CardProblem
+	Fixed       int
+	Quarantined int
+}
+
+func (self *DoctorReport) String() string {
+	s := fmt.Sprintf("checked %d cards, found %d with problems\n", self.Checked, len(self.Found))
+	for _, found := range self.Found {
+		action := "quarantine"
+		if found.Fixed != nil {
+			action = "fix"
+		}
+
+		s += fmt.Sprintf("  %s (%s)\n", found.Key, action)
+		for _, problem := range found.Problems {
+			s += fmt.Sprintf("    %s\n", problem)
+		}
+	}
+
+	if self.Fixed > 0 || self.Quarantined > 0 {
+		s += fmt.Sprintf("fixed %d cards, quarantined %d cards\n", self.Fixed, self.Quarantined)
+	}
+
+	return s
+}
+
+// Check a card which was read successfully. Schedules can be repaired, but a
+// card whose exercise can't be played is only fit for quarantine.
+func CheckCard(card Card, now time.Time) CardProblem {
+	found := CardProblem{Key: card.ID}
+	fixed := card
+	fixable := true
+
+	if !isExerciseType(card.ExerciseType) {
+		found.Problems = append(found.Problems, fmt.Sprintf("unknown exercise type %q", card.ExerciseType))
+		fixable = false
+	} else if exercise := CreateExercise(card); !exercise.Definition.Playable() {
+		found.Problems = append(found.Problems, fmt.Sprintf("%s definition %q has no playable notes", card.ExerciseType, card.ExerciseDefinition))
+		fixable = false
+	}
+
+	if ef := float64(card.Ef); math.IsNaN(ef) || math.IsInf(ef, 0) {
+		found.Problems = append(found.Problems, fmt.Sprintf("ease factor is %v, resetting to 2.5", card.Ef))
+		fixed.Ef = 2.5
+	} else if card.Ef < MinEf {
+		found.Problems = append(found.Problems, fmt.Sprintf("ease factor %v is below %v", card.Ef, MinEf))
+		fixed.Ef = MinEf
+	} else if card.Ef > MaxEf {
+		found.Problems = append(found.Problems, fmt.Sprintf("ease factor %v is above %v", card.Ef, MaxEf))
+		fixed.Ef = MaxEf
+	}
+
+	if card.LastRecalledAt.After(now) {
+		found.Problems = append(found.Problems, fmt.Sprintf("last recalled in the future (%s), resetting to now", card.LastRecalledAt.Format(time.RFC3339)))
+		fixed.LastRecalledAt = now
+	}
+
+	if fixable && len(found.Problems) > 0 {
+		found.Fixed = &fixed
+	}
+
+	return found
+}
+
+func isExerciseType(exerciseType string) bool {
+	for _, t := range ExerciseTypes {
+		if t == exerciseType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Find every card with problems, including cards which can't be read at all
+func CheckCards(store Store, now time.Time) (DoctorReport, error) {
+	var report DoctorReport
+
+	err := store.ScanCards(func(key string, card Card, err error) error {
+		report.Checked++
+
+		if err != nil {
+			report.Found = append(report.Found, CardProblem{Key: key, Problems: []string{fmt.Sprintf("can't be read: %v", err)}})
+			return nil
+		}
+
+		found := CheckCard(card, now)
+		if key != card.ID {
+			// Writing a fixed card would leave the original behind under the old key
+			found.Problems = append(found.Problems, fmt.Sprintf("stored under key %q but has ID %q", key, card.ID))
+			found.Fixed = nil
+		}
+
+		if len(found.Problems) > 0 {
+			found.Key = key
+			report.Found = append(report.Found, found)
+		}
+
+		return nil
+	})
+
+	return report, err
+}
+
+// Fix or quarantine every card found by CheckCards
+func RepairCards(store Store, report *DoctorReport) error {
+	for _, found := range report.Found {
+		if found.Fixed != nil {
+			if err := store.Upsert(*found.Fixed); err != nil {
+				return err
+			}
+			report.Fixed++
+			continue
+		}
+
+		if err := store.QuarantineCard(found.Key); err != nil {
+			return err
+		}
+		report.Quarantined++
+	}
+
+	return nil
+}
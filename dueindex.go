@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+// Secondary index of unsuspended cards by when they're next due, so finding due
+// cards doesn't need to read every card. Buried cards are indexed by the end of
+// their burial, so they're only visited once they can be practised again.
+// Index entries have no value.
+var DueBucket = []byte("due")
+
+// The time a card is indexed under
+func dueIndexTime(card Card) time.Time {
+	due := NextRecallTime(card)
+	if card.BuriedUntil.After(due) {
+		return card.BuriedUntil
+	}
+
+	return due
+}
+
+// Index keys are the due time in seconds, encoded to sort correctly
+// even before 1970 (new cards are due at the zero time), followed by the card key
+func dueIndexKey(due time.Time, key []byte) []byte {
+	k := make([]byte, 8, 8+len(key))
+	binary.BigEndian.PutUint64(k, uint64(due.Unix())^(1<<63))
+	return append(k, key...)
+}
+
+func decodeDueIndexKey(k []byte) (time.Time, []byte) {
+	seconds := int64(binary.BigEndian.Uint64(k[:8]) ^ (1 << 63))
+	return time.Unix(seconds, 0), k[8:]
+}
+
+// Remove a card's index entry, if it has one
+func unindexCard(tx *bolt.Tx, key []byte) error {
+	v := tx.Bucket(CardBucket).Get(key)
+	if v == nil {
+		return nil
+	}
+
+	card, err := DeserializeCard(v)
+	if err != nil {
+		// Can't find the entry for an unreadable card. Readers skip entries
+		// which don't match their card, so a stale entry is harmless.
+		return nil
+	}
+
+	return tx.Bucket(DueBucket).Delete(dueIndexKey(dueIndexTime(card), key))
+}
+
+// Write a card, keeping the due-date index in step with it. All writes to the
+// cards bucket should go through this (or deleteCard).
+func putCard(tx *bolt.Tx, card Card) error {
+	if err := unindexCard(tx, card.Key()); err != nil {
+		return err
+	}
+
+	v, err := card.Serialize()
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Bucket(CardBucket).Put(card.Key(), v); err != nil {
+		return err
+	}
+
+	if card.Suspended {
+		return nil
+	}
+
+	return tx.Bucket(DueBucket).Put(dueIndexKey(dueIndexTime(card), card.Key()), []byte{})
+}
+
+func deleteCard(tx *bolt.Tx, key []byte) error {
+	if err := unindexCard(tx, key); err != nil {
+		return err
+	}
+
+	return tx.Bucket(CardBucket).Delete(key)
+}
+
+// Visit index entries in order of next recall time, up to (not including) the
+// given time, along with their cards. Stale entries are skipped.
+func forEachDue(tx *bolt.Tx, before time.Time, f func(card Card) error) error {
+	cards := tx.Bucket(CardBucket)
+	end := dueIndexKey(before, nil)
+
+	c := tx.Bucket(DueBucket).Cursor()
+	for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.Next() {
+		due, key := decodeDueIndexKey(k)
+
+		v := cards.Get(key)
+		if v == nil {
+			continue
+		}
+
+		card, err := DeserializeCard(v)
+		if err != nil || card.Suspended || dueIndexTime(card).Unix() != due.Unix() {
+			continue
+		}
+
+		if err := f(card); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *BoltStore) DueForecast(now time.Time, days int) ([]int, error) {
+	forecast := make([]int, days)
+	end := startOfDay(now).AddDate(0, 0, days)
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		return forEachDue(tx, end, func(card Card) error {
+			day := max(0, daysBetween(now, dueIndexTime(card)))
+			if day < days {
+				forecast[day]++
+			}
+			return nil
+		})
+	})
+
+	return forecast, err
+}
+
+// Recreate the index from the cards bucket
+func rebuildDueIndex(tx *bolt.Tx, report *MigrationReport) error {
+	if err := tx.DeleteBucket(DueBucket); err != nil && err != bolt.ErrBucketNotFound {
+		return err
+	}
+
+	due, err := tx.CreateBucket(DueBucket)
+	if err != nil {
+		return err
+	}
+
+	indexed := 0
+	err = tx.Bucket(CardBucket).ForEach(func(k, v []byte) error {
+		card, err := DeserializeCard(v)
+		if err != nil {
+			report.skipCard(k, err)
+			return nil
+		}
+
+		if card.Suspended {
+			return nil
+		}
+
+		indexed++
+		return due.Put(dueIndexKey(dueIndexTime(card), k), []byte{})
+	})
+
+	report.Logf("indexed %d cards", indexed)
+	return err
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// Both backends count a buried card on the day its burial ends
+func TestDueForecastBuriedCard(t *testing.T) {
+	now := time.Now()
+	forecasts := map[string][]int{}
+
+	for _, name := range []string{"cards.db", "cards.sqlite"} {
+		store, err := Connect(filepath.Join(t.TempDir(), name))
+		if err != nil {
+			if isSQLitePath(name) {
+				t.Logf("skipping SQLite: %v", err)
+				continue
+			}
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		cards, err := store.AllCards()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buried := cards[0]
+		buried.BuriedUntil = startOfDay(now).AddDate(0, 0, 2)
+		if err := store.Upsert(buried); err != nil {
+			t.Fatal(err)
+		}
+
+		forecast, err := store.DueForecast(now, 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := []int{len(cards) - 1, 0, 1}; !reflect.DeepEqual(forecast, want) {
+			t.Errorf("%s forecast is %v, want %v", name, forecast, want)
+		}
+		forecasts[name] = forecast
+
+		due, err := store.DueCards(now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(due) != len(cards)-1 {
+			t.Errorf("%s has %d cards due, want %d", name, len(due), len(cards)-1)
+		}
+	}
+
+	if sqlite, ok := forecasts["cards.sqlite"]; ok && !reflect.DeepEqual(sqlite, forecasts["cards.db"]) {
+		t.Errorf("forecasts differ between bolt (%v) and SQLite (%v)", forecasts["cards.db"], sqlite)
+	}
+}
@@ -4,8 +4,12 @@ import (
 	"gopkg.in/music-theory.v0/chord"
 	"gopkg.in/music-theory.v0/note"
 	"gopkg.in/music-theory.v0/scale"
+	"strings"
 )
 
+// Exercise types which CreateExercise understands
+var ExerciseTypes = []string{"note", "chord", "scale"}
+
 type ExerciseDefinition struct {
 	Name  string
 	Parts [][]note.Class
@@ -24,35 +28,39 @@ func parseNote(n string) note.Class {
 	case "C#":
 		return note.Cs
 	case "Cb":
-		return note.Cs
+		return note.B
 	case "D":
 		return note.D
 	case "D#":
 		return note.Ds
 	case "Db":
-		return note.Ds
+		return note.Cs
 	case "E":
 		return note.E
+	case "Eb":
+		return note.Ds
 	case "F":
 		return note.F
 	case "F#":
 		return note.Fs
 	case "Fb":
-		return note.Fs
+		return note.E
 	case "G":
 		return note.G
 	case "G#":
 		return note.Gs
 	case "Gb":
-		return note.Gs
+		return note.Fs
 	case "A":
 		return note.A
 	case "A#":
 		return note.As
 	case "Ab":
-		return note.As
+		return note.Gs
 	case "B":
 		return note.B
+	case "Bb":
+		return note.As
 	}
 
 	return note.Nil
@@ -70,7 +78,7 @@ func notesToClasses(notes []*note.Note) []note.Class {
 
 func CreateExercise(card Card) Exercise {
 	var definition ExerciseDefinition
-	definition.Name = card.Name
+	definition.Name = card.DisplayName()
 
 	switch card.ExerciseType {
 	case "note":
@@ -93,6 +101,22 @@ func CreateExercise(card Card) Exercise {
 	}
 }
 
+// Whether every step of the exercise has notes which can be played. Definitions
+// which can't be parsed produce empty steps or notes of the Nil class.
+func (self *ExerciseDefinition) Playable() bool {
+	if len(self.Parts) == 0 {
+		return false
+	}
+
+	for _, part := range self.Parts {
+		if len(part) == 0 || noteArrayContains(part, note.Nil) {
+			return false
+		}
+	}
+
+	return true
+}
+
 func (e *Exercise) Reset() {
 	e.CurrentStep = 0
 	e.CurrentNotes = []note.Class{}
@@ -133,3 +157,19 @@ func noteArrayContains(notes []note.Class, n note.Class) bool {
 
 	return false
 }
+
+// Spell out the notes of a card's exercise, e.g. "E♭ G B♭", using the
+// accidentals of the exercise's root note
+func SpellExercise(card Card) string {
+	adj := note.AdjSymbolOf(card.ExerciseDefinition)
+	exercise := CreateExercise(card)
+
+	names := []string{}
+	for _, part := range exercise.Definition.Parts {
+		for _, n := range part {
+			names = append(names, n.String(adj))
+		}
+	}
+
+	return strings.Join(names, " ")
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Everything needed to move progress to another machine
+type Export struct {
+	Version int
+	Cards   []Card
+	Reviews []Review
+}
+
+// How imported cards are combined with cards already in the database
+type MergeMode string
+
+const (
+	// Keep whichever copy of a card was reviewed most recently
+	MergeNewer MergeMode = "newer"
+	// Always replace existing cards with imported ones
+	MergeOverwrite MergeMode = "overwrite"
+	// Only add cards which don't exist yet
+	MergeSkip MergeMode = "skip"
+)
+
+func ParseMergeMode(s string) (MergeMode, error) {
+	switch mode := MergeMode(s); mode {
+	case MergeNewer, MergeOverwrite, MergeSkip:
+		return mode, nil
+	}
+
+	return "", fmt.Errorf("unknown merge mode %q (expected newer, overwrite or skip)", s)
+}
+
+type ImportReport struct {
+	Added           int
+	Updated         int
+	Skipped         int
+	ReviewsAdded    int
+	ReviewsExisting int
+}
+
+func ExportStore(store Store) (Export, error) {
+	cards, err := store.AllCards()
+	if err != nil {
+		return Export{}, err
+	}
+
+	reviews, err := store.Reviews(time.Time{})
+	if err != nil {
+		return Export{}, err
+	}
+
+	return Export{Version: LatestSchemaVersion(), Cards: cards, Reviews: reviews}, nil
+}
+
+// Whether an imported card should replace the existing copy
+func shouldReplace(existing, imported Card, mode MergeMode) bool {
+	return mode == MergeOverwrite || (mode == MergeNewer && imported.LastRecalledAt.After(existing.LastRecalledAt))
+}
+
+// Merge exported cards and reviews into the database in a single transaction.
+// Reviews are identified by ID, so importing the same reviews twice is harmless.
+func (self *BoltStore) Import(data Export, mode MergeMode) (ImportReport, error) {
+	var report ImportReport
+
+	if err := checkExportVersion(data); err != nil {
+		return report, err
+	}
+
+	err := self.db.Update(func(tx *bolt.Tx) error {
+		cards := tx.Bucket(CardBucket)
+
+		for _, card := range data.Cards {
+			if card.ID == "" {
+				card.ID = CardID(card.ExerciseType, card.ExerciseDefinition)
+			}
+
+			if v := cards.Get(card.Key()); v != nil {
+				existing, err := DeserializeCard(v)
+				if err != nil {
+					return err
+				}
+
+				if !shouldReplace(existing, card, mode) {
+					report.Skipped++
+					continue
+				}
+
+				report.Updated++
+			} else {
+				report.Added++
+			}
+
+			if err := putCard(tx, card); err != nil {
+				return err
+			}
+		}
+
+		reviews := tx.Bucket(ReviewBucket)
+		for _, review := range data.Reviews {
+			if reviews.Get(review.Key()) != nil {
+				report.ReviewsExisting++
+				continue
+			}
+
+			r, err := review.Serialize()
+			if err != nil {
+				return err
+			}
+
+			if err := reviews.Put(review.Key(), r); err != nil {
+				return err
+			}
+
+			report.ReviewsAdded++
+		}
+
+		return nil
+	})
+
+	return report, err
+}
+
+func checkExportVersion(data Export) error {
+	if data.Version > LatestSchemaVersion() {
+		return fmt.Errorf("export version %d is newer than this version of chordy supports (%d)", data.Version, LatestSchemaVersion())
+	}
+
+	return nil
+}
+
+func WriteExportJSON(w io.Writer, data Export) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+func ReadExportJSON(r io.Reader) (Export, error) {
+	var data Export
+	err := json.NewDecoder(r).Decode(&data)
+	return data, err
+}
+
+var cardCSVHeader = []string{"id", "name", "type", "definition", "recalls", "ef", "interval", "last_recalled_at", "suspended", "buried_until", "tags", "changed_at"}
+var reviewCSVHeader = []string{"id", "card", "grade", "reviewed_at", "duration_seconds", "recalls", "ef", "interval", "cram"}
+
+// Columns added after the CSV format was introduced, which older exports don't have
+var optionalCSVColumns = map[string]bool{"cram": true, "changed_at": true}
+
+func formatCSVTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return t.Format(time.RFC3339Nano)
+}
+
+func parseCSVTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+func WriteCardsCSV(w io.Writer, cards []Card) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(cardCSVHeader); err != nil {
+		return err
+	}
+
+	for _, card := range cards {
+		err := writer.Write([]string{
+			card.ID,
+			card.Name,
+			card.ExerciseType,
+			card.ExerciseDefinition,
+			strconv.FormatUint(uint64(card.Recalls), 10),
+			strconv.FormatFloat(float64(card.Ef), 'f', -1, 32),
+			strconv.FormatUint(uint64(card.Interval), 10),
+			formatCSVTime(card.LastRecalledAt),
+			strconv.FormatBool(card.Suspended),
+			formatCSVTime(card.BuriedUntil),
+			strings.Join(card.Tags, ";"),
+			formatCSVTime(card.ChangedAt),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+func WriteReviewsCSV(w io.Writer, reviews []Review) error {
+	writer := csv.NewWriter(w)
+
+	if err := writer.Write(reviewCSVHeader); err != nil {
+		return err
+	}
+
+	for _, review := range reviews {
+		err := writer.Write([]string{
+			review.ID,
+			review.CardKey,
+			strconv.FormatUint(uint64(review.Grade), 10),
+			formatCSVTime(review.ReviewedAt),
+			strconv.FormatFloat(review.Duration.Seconds(), 'f', -1, 64),
+			strconv.FormatUint(uint64(review.Recalls), 10),
+			strconv.FormatFloat(float64(review.Ef), 'f', -1, 32),
+			strconv.FormatUint(uint64(review.Interval), 10),
+			strconv.FormatBool(review.Cram),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// Read CSV rows as maps from column name to value, checking the expected columns are present
+func readCSVRows(r io.Reader, header []string) ([]map[string]string, error) {
+	records, err := csv.NewReader(r).ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(records) == 0 {
+		return nil, fmt.Errorf("missing CSV header")
+	}
+
+	columns := map[string]int{}
+	for i, name := range records[0] {
+		columns[name] = i
+	}
+
+	for _, name := range header {
+		if _, ok := columns[name]; !ok && !optionalCSVColumns[name] {
+			return nil, fmt.Errorf("missing CSV column %q", name)
+		}
+	}
+
+	rows := []map[string]string{}
+	for _, record := range records[1:] {
+		row := map[string]string{}
+		for _, name := range header {
+			if i, ok := columns[name]; ok {
+				row[name] = record[i]
+			}
+		}
+		rows = append(rows, row)
+	}
+
+	return rows, nil
+}
+
+// Collects the first error from a series of field conversions
+type fieldParser struct {
+	line int
+	err  error
+}
+
+func (self *fieldParser) uint(s string) uint {
+	n, err := strconv.ParseUint(s, 10, 32)
+	self.check(err)
+	return uint(n)
+}
+
+func (self *fieldParser) float(s string) float64 {
+	n, err := strconv.ParseFloat(s, 64)
+	self.check(err)
+	return n
+}
+
+func (self *fieldParser) bool(s string) bool {
+	b, err := strconv.ParseBool(s)
+	self.check(err)
+	return b
+}
+
+func (self *fieldParser) time(s string) time.Time {
+	t, err := parseCSVTime(s)
+	self.check(err)
+	return t
+}
+
+func (self *fieldParser) check(err error) {
+	if err != nil && self.err == nil {
+		self.err = fmt.Errorf("line %d: %v", self.line, err)
+	}
+}
+
+func ReadCardsCSV(r io.Reader) ([]Card, error) {
+	rows, err := readCSVRows(r, cardCSVHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	cards := []Card{}
+	for i, row := range rows {
+		p := fieldParser{line: i + 2}
+
+		card := Card{
+			ID:                 row["id"],
+			Name:               row["name"],
+			ExerciseType:       row["type"],
+			ExerciseDefinition: row["definition"],
+			Recalls:            p.uint(row["recalls"]),
+			Ef:                 float32(p.float(row["ef"])),
+			Interval:           p.uint(row["interval"]),
+			LastRecalledAt:     p.time(row["last_recalled_at"]),
+			Suspended:          p.bool(row["suspended"]),
+			BuriedUntil:        p.time(row["buried_until"]),
+			ChangedAt:          p.time(row["changed_at"]),
+		}
+
+		if row["tags"] != "" {
+			card.Tags = strings.Split(row["tags"], ";")
+		}
+
+		if p.err != nil {
+			return nil, p.err
+		}
+
+		cards = append(cards, card)
+	}
+
+	return cards, nil
+}
+
+func ReadReviewsCSV(r io.Reader) ([]Review, error) {
+	rows, err := readCSVRows(r, reviewCSVHeader)
+	if err != nil {
+		return nil, err
+	}
+
+	reviews := []Review{}
+	for i, row := range rows {
+		p := fieldParser{line: i + 2}
+
+		review := Review{
+			ID:         row["id"],
+			CardKey:    row["card"],
+			Grade:      p.uint(row["grade"]),
+			ReviewedAt: p.time(row["reviewed_at"]),
+			Duration:   time.Duration(p.float(row["duration_seconds"]) * float64(time.Second)),
+			Recalls:    p.uint(row["recalls"]),
+			Ef:         float32(p.float(row["ef"])),
+			Interval:   p.uint(row["interval"]),
+			Cram:       row["cram"] != "" && p.bool(row["cram"]),
+		}
+
+		if p.err != nil {
+			return nil, p.err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	return reviews, nil
+}
@@ -6,6 +6,7 @@ require (
 	github.com/boltdb/bolt v1.3.1 // indirect
 	github.com/gizak/termui/v3 v3.1.0 // indirect
 	github.com/gpayer/go-audio-service v0.0.0-20190527202639-d40d5fccb701 // indirect
+	github.com/mattn/go-sqlite3 v1.14.15 // indirect
 	github.com/rakyll/portmidi v0.0.0-20201020180702-d436ceaa537a // indirect
 	github.com/spf13/viper v1.7.1 // indirect
 	gitlab.com/gomidi/midi v1.20.2 // indirect
@@ -4,8 +4,10 @@ import (
 	"fmt"
 	ui "github.com/gizak/termui/v3"
 	"github.com/gizak/termui/v3/widgets"
+	"github.com/spf13/viper"
 	mt "gopkg.in/music-theory.v0/note"
 	"image"
+	"math"
 )
 
 var NormalStyle ui.Style = ui.NewStyle(ui.ColorWhite)
@@ -96,6 +98,62 @@ func (self *ExerciseWidget) Draw(buf *ui.Buffer) {
 	}
 }
 
+// Shades for days in the practice heatmap, from no practice to meeting the daily goal
+var HeatmapShades = []ui.Color{ui.Color(236), ui.Color(22), ui.Color(28), ui.Color(34), ui.Color(40)}
+
+// Calendar of practice, one column per week, in the style of GitHub's contribution graph
+type HeatmapWidget struct {
+	ui.Block
+	practice []PracticeDay
+	goal     int
+}
+
+func NewHeatmapWidget(practice []PracticeDay, goal int) *HeatmapWidget {
+	return &HeatmapWidget{Block: *ui.NewBlock(), practice: practice, goal: goal}
+}
+
+func (self *HeatmapWidget) shade(day PracticeDay) ui.Color {
+	if day.Reviews == 0 {
+		return HeatmapShades[0]
+	}
+
+	if self.goal <= 0 || day.Reviews >= self.goal {
+		return HeatmapShades[len(HeatmapShades)-1]
+	}
+
+	level := 1 + (len(HeatmapShades)-2)*day.Reviews/self.goal
+	return HeatmapShades[level]
+}
+
+func (self *HeatmapWidget) Draw(buf *ui.Buffer) {
+	self.Block.Draw(buf)
+
+	if len(self.practice) == 0 {
+		return
+	}
+
+	// Each week takes two cells, and the most recent weeks are shown if they don't all fit
+	weeks := (self.Inner.Max.X - self.Inner.Min.X) / 2
+	today := self.practice[len(self.practice)-1].Date
+	lastWeek := weeks - 1
+	firstDay := today.AddDate(0, 0, -int(today.Weekday())-7*lastWeek)
+
+	for _, day := range self.practice {
+		offset := daysBetween(firstDay, day.Date)
+		if offset < 0 {
+			continue
+		}
+
+		x := self.Inner.Min.X + 2*(offset/7)
+		y := self.Inner.Min.Y + offset%7
+		if y >= self.Inner.Max.Y {
+			continue
+		}
+
+		buf.SetCell(ui.NewCell('■', ui.NewStyle(self.shade(day))), image.Pt(x, y))
+	}
+}
+
 // MAIN UI
 
 func InitUI() error {
@@ -115,44 +173,90 @@ func ClearUI() {
 }
 
 func RenderUI(app *App) {
+	if app.transcript != nil {
+		app.writeTranscript()
+		return
+	}
+
 	switch app.state {
 	case StateHome:
 		renderHome(app)
 	case StateInSession:
 		renderInSession(app)
+	case StateStats:
+		renderStats(app)
+	case StateProfiles:
+		renderProfiles(app)
+	case StateLearn:
+		renderLearn(app)
+	}
+
+	if app.controller == ControllerDisconnected {
+		renderDisconnected()
 	}
 }
 
-func padRow(ratio float64, a, b, c, d string) ui.GridItem {
-	aP := widgets.NewParagraph()
-	aP.Text = a
-	bP := widgets.NewParagraph()
-	bP.Text = b
-	cP := widgets.NewParagraph()
-	cP.Text = c
-	dP := widgets.NewParagraph()
-	dP.Text = d
+// A row with a box for each pad, labelled with what it does
+func padRow(ratio float64, labels ...string) ui.GridItem {
+	cols := []interface{}{}
+	for _, label := range labels {
+		p := widgets.NewParagraph()
+		p.Text = label
+		cols = append(cols, ui.NewCol(1.0/float64(len(labels)), p))
+	}
 
-	return ui.NewRow(
-		ratio,
-		ui.NewCol(1.0/4, aP),
-		ui.NewCol(1.0/4, bP),
-		ui.NewCol(1.0/4, cP),
-		ui.NewCol(1.0/4, dP),
-	)
+	return ui.NewRow(ratio, cols...)
 }
 
 func renderHome(app *App) {
 	p := widgets.NewParagraph()
 	p.Text = "Welcome to Chordy\nPlay any note to start a new session!"
+	p.Text += fmt.Sprintf("\n\nProfile: %s (press p to switch)", CurrentProfile)
+	p.Text += "\nPress l to set up your controller's pads"
+	if app.controller == ControllerNone {
+		p.Text += "\n\nNo MIDI controller found, so the computer keyboard is a piano:\n" +
+			"a w s e d f t g y h u j k play C to C, z and x change octave, number keys are the pads"
+	}
+	if app.backupErr != nil {
+		p.Text += fmt.Sprintf("\n\nBackup failed: %v", app.backupErr)
+	}
+	if app.recordingErr != nil {
+		p.Text += fmt.Sprintf("\n\nCould not save recording: %v", app.recordingErr)
+	}
+	if app.stateHome.err != nil {
+		p.Text += fmt.Sprintf("\n\nCould not start a session: %v", app.stateHome.err)
+	}
 
 	p.SetRect(0, 0, 25, 5)
 
+	goal := viper.GetInt("DailyGoal")
+	var today PracticeDay
+	if n := len(app.stateHome.practice); n > 0 {
+		today = app.stateHome.practice[n-1]
+	}
+
+	heatmap := NewHeatmapWidget(app.stateHome.practice, goal)
+	heatmap.Title = "Practice"
+
+	streak := widgets.NewParagraph()
+	streak.Title = "Streak"
+	streak.Text = fmt.Sprintf("%v days\nToday: %v reviews in %v min", app.stateHome.streak, today.Reviews, int(today.Duration.Minutes()))
+
+	progress := widgets.NewGauge()
+	progress.Title = "Daily Goal"
+	if goal > 0 {
+		progress.Percent = min(100, 100*today.Reviews/goal)
+	}
+	progress.Label = fmt.Sprintf("%v/%v reviews", today.Reviews, goal)
+	progress.BarColor = ui.ColorGreen
+
 	grid := ui.NewGrid()
 	termWidth, termHeight := ui.TerminalDimensions()
 	grid.SetRect(0, 0, termWidth, termHeight)
 	grid.Set(
 		ui.NewRow(1.0/2, ui.NewCol(1.0, p)),
+		ui.NewRow(1.0/4, ui.NewCol(1.0, heatmap)),
+		ui.NewRow(1.0/4, ui.NewCol(1.0/2, streak), ui.NewCol(1.0/2, progress)),
 	)
 
 	ui.Render(grid)
@@ -161,6 +265,9 @@ func renderHome(app *App) {
 func renderInSession(app *App) {
 	p := widgets.NewGauge()
 	p.Title = "Session Progress"
+	if !app.stateInSession.reschedule {
+		p.Title = "Cram Progress (schedule unchanged)"
+	}
 	p.Percent = int(100.0 * float32(app.stateInSession.currentIndex) / float32(len(app.stateInSession.cards)))
 	p.Label = fmt.Sprintf("%v%% (%v/%v)", p.Percent, app.stateInSession.currentIndex, len(app.stateInSession.cards))
 
@@ -174,20 +281,18 @@ func renderInSession(app *App) {
 		lastSeen = fmt.Sprintf("%v", card.LastRecalledAt)
 	}
 
-	info.Text = fmt.Sprintf("Name: %v\nLast seen: %v\nEstimated difficulty: %v", card.Name, lastSeen, card.Ef)
+	info.Text = fmt.Sprintf("Name: %v\nLast seen: %v\nEstimated difficulty: %v", card.DisplayName(), lastSeen, card.Ef)
+	if app.stateInSession.err != nil {
+		info.Text += fmt.Sprintf("\n\nCould not set the card aside: %v", app.stateInSession.err)
+	}
 
 	e := NewExerciseWidget(app.stateInSession)
 
-	var pads ui.GridItem
-
-	switch app.stateInSession.state {
-	case ExerciseInProgress:
-		pads = padRow(1.0/4, "Give up", "Hint", "", "")
-	case ExerciseFail:
-		pads = padRow(1.0/4, "Retry", "Continue", "", "")
-	case ExercisePass:
-		pads = padRow(1.0/4, "Easy", "Normal", "Hard", "")
+	labels := []string{}
+	for _, pad := range Pads {
+		labels = append(labels, app.actionLabel(pad.Action))
 	}
+	pads := padRow(1.0/4, labels...)
 
 	grid := ui.NewGrid()
 	termWidth, termHeight := ui.TerminalDimensions()
@@ -200,3 +305,150 @@ func renderInSession(app *App) {
 
 	ui.Render(grid)
 }
+
+func barChart(title string, labels []string, data []float64) *widgets.BarChart {
+	chart := widgets.NewBarChart()
+	chart.Title = title
+	chart.Labels = labels
+	chart.Data = data
+	chart.BarGap = 1
+	chart.NumFormatter = func(n float64) string { return fmt.Sprintf("%.0f", n) }
+
+	// An empty chart would otherwise divide by zero when scaling its bars
+	chart.MaxVal = 1
+	for _, d := range data {
+		if d > chart.MaxVal {
+			chart.MaxVal = d
+		}
+	}
+
+	return chart
+}
+
+func renderStats(app *App) {
+	stats := app.stateStats.stats
+	termWidth, termHeight := ui.TerminalDimensions()
+
+	counts := widgets.NewParagraph()
+	counts.Title = "Cards"
+	counts.Text = fmt.Sprintf("New: %v\nLearning: %v\nMature: %v\nSuspended: %v\n\nPress Tab to go back",
+		stats.New, stats.Learning, stats.Mature, stats.Suspended)
+
+	forecastLabels := []string{}
+	forecastData := []float64{}
+	for day, due := range stats.DueForecast {
+		forecastLabels = append(forecastLabels, fmt.Sprint(day))
+		forecastData = append(forecastData, float64(due))
+	}
+	forecast := barChart("Due in the next 30 days", forecastLabels, forecastData)
+	forecast.BarWidth = max(1, (termWidth*3/4)/ForecastDays-forecast.BarGap)
+
+	retentionLabels := []string{}
+	retentionData := []float64{}
+	for _, bucket := range stats.Retention {
+		retentionLabels = append(retentionLabels, bucket.Label)
+		retentionData = append(retentionData, bucket.Percent())
+	}
+	retention := barChart("Retention by interval (%)", retentionLabels, retentionData)
+	retention.MaxVal = 100
+	retention.BarWidth = 6
+
+	efLabels := []string{}
+	efData := []float64{}
+	for _, bucket := range stats.EfHistogram {
+		efLabels = append(efLabels, bucket.Label)
+		efData = append(efData, float64(bucket.Cards))
+	}
+	ef := barChart("Ease factor", efLabels, efData)
+	ef.BarWidth = 4
+
+	line := widgets.NewSparkline()
+	line.MaxVal = 1
+	for _, n := range stats.ReviewsPerDay {
+		line.Data = append(line.Data, float64(n))
+		line.MaxVal = math.Max(line.MaxVal, float64(n))
+	}
+	line.LineColor = ui.ColorGreen
+	reviews := widgets.NewSparklineGroup(line)
+	reviews.Title = "Reviews over the last 30 days"
+
+	grid := ui.NewGrid()
+	grid.SetRect(0, 0, termWidth, termHeight)
+	grid.Set(
+		ui.NewRow(1.0/3, ui.NewCol(3.0/4, forecast), ui.NewCol(1.0/4, counts)),
+		ui.NewRow(1.0/3, ui.NewCol(1.0/2, retention), ui.NewCol(1.0/2, ef)),
+		ui.NewRow(1.0/3, ui.NewCol(1.0, reviews)),
+	)
+
+	ui.Render(grid)
+}
+
+func renderProfiles(app *App) {
+	list := widgets.NewList()
+	list.Title = "Profiles"
+	for _, profile := range app.stateProfiles.profiles {
+		if profile == CurrentProfile {
+			profile += " (current)"
+		}
+		list.Rows = append(list.Rows, profile)
+	}
+	list.SelectedRow = app.stateProfiles.selected
+	list.SelectedRowStyle = ui.NewStyle(ui.ColorBlack, ui.ColorGreen)
+
+	help := widgets.NewParagraph()
+	help.Text = "Up/Down to choose, Enter to switch, p or Esc to go back\nCreate a profile by starting chordy with -profile <name>"
+	if app.stateProfiles.err != nil {
+		help.Text += fmt.Sprintf("\n\nCould not switch profile: %v", app.stateProfiles.err)
+	}
+
+	grid := ui.NewGrid()
+	termWidth, termHeight := ui.TerminalDimensions()
+	grid.SetRect(0, 0, termWidth, termHeight)
+	grid.Set(
+		ui.NewRow(3.0/4, ui.NewCol(1.0, list)),
+		ui.NewRow(1.0/4, ui.NewCol(1.0, help)),
+	)
+
+	ui.Render(grid)
+}
+
+func renderLearn(app *App) {
+	next := len(app.stateLearn.learned)
+
+	p := widgets.NewParagraph()
+	p.Title = "Set up pads"
+	p.Text = fmt.Sprintf("Press pad %s (%s) on your controller\n\n", padName(SelectionKey(next+1)), Pads[next].Action)
+	for i, binding := range app.stateLearn.learned {
+		p.Text += fmt.Sprintf("Pad %s: %s\n", padName(SelectionKey(i+1)), binding)
+	}
+	if app.stateLearn.err != nil {
+		p.Text += fmt.Sprintf("\n%v\n", app.stateLearn.err)
+	}
+	p.Text += "\nPress Esc to cancel"
+
+	labels := make([]string, len(Pads))
+	labels[next] = "Press me"
+
+	grid := ui.NewGrid()
+	termWidth, termHeight := ui.TerminalDimensions()
+	grid.SetRect(0, 0, termWidth, termHeight)
+	grid.Set(
+		ui.NewRow(3.0/4, ui.NewCol(1.0, p)),
+		padRow(1.0/4, labels...),
+	)
+
+	ui.Render(grid)
+}
+
+// Banner shown over every screen while the controller is unplugged
+func renderDisconnected() {
+	banner := widgets.NewParagraph()
+	banner.Text = "Controller disconnected - plug it back in to carry on"
+	banner.TextStyle = ui.NewStyle(ui.ColorWhite, ui.ColorRed, ui.ModifierBold)
+	banner.BorderStyle = ui.NewStyle(ui.ColorRed)
+
+	termWidth, _ := ui.TerminalDimensions()
+	banner.SetRect(0, 0, termWidth, 3)
+
+	ui.Render(banner)
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"gitlab.com/gomidi/midi"
+	"gitlab.com/gomidi/midi/midimessage/channel"
+	"gitlab.com/gomidi/midi/reader"
+	"gitlab.com/gomidi/rtmididrv"
+	"sync"
+	"time"
+)
+
+type InputEventType uint8
+
+const (
+	NoteOnEvent InputEventType = iota
+	NoteOffEvent
+	PadOnEvent
+	PadOffEvent
+	// A source's device was plugged in or unplugged
+	ConnectedEvent
+	DisconnectedEvent
+	// A message which isn't a note or bound to a pad, only used when learning
+	// pads
+	ControlEvent
+	// The sustain pedal was pressed or released, when it isn't bound to an
+	// action
+	SustainOnEvent
+	SustainOffEvent
+)
+
+// The controller sent by sustain pedals
+const SustainController = 64
+
+// Something the user played, independent of where it came from
+type InputEvent struct {
+	Type InputEventType
+	// MIDI key number, for note events
+	Key      uint8
+	Velocity uint8
+	// For pad events
+	Pad SelectionKey
+	// The MIDI message behind the event, if it came from MIDI
+	Message midi.Message
+}
+
+// Turn a MIDI message into events. Pads are told apart by the pad binding
+// settings, and other messages on the pad channel are left out so pads can't
+// be played as notes. Other messages are ignored.
+func ClassifyMidi(msg midi.Message) []InputEvent {
+	if pad, pressed := getSelectionKey(msg); pad != KeyInvalid {
+		if _, ok := msg.(channel.ProgramChange); ok {
+			// The release has no message of its own, so isn't recorded twice
+			return []InputEvent{
+				{Type: PadOnEvent, Pad: pad, Message: msg},
+				{Type: PadOffEvent, Pad: pad},
+			}
+		}
+
+		event := InputEvent{Type: PadOffEvent, Pad: pad, Message: msg}
+		if pressed {
+			event.Type = PadOnEvent
+		}
+		return []InputEvent{event}
+	}
+
+	var event InputEvent
+	switch m := msg.(type) {
+	case channel.NoteOn:
+		// Controllers often release notes with a note on of velocity 0
+		if m.Velocity() == 0 {
+			event = InputEvent{Type: NoteOffEvent, Key: m.Key()}
+		} else {
+			event = InputEvent{Type: NoteOnEvent, Key: m.Key(), Velocity: m.Velocity()}
+		}
+	case channel.NoteOff:
+		event = InputEvent{Type: NoteOffEvent, Key: m.Key()}
+	case channel.NoteOffVelocity:
+		event = InputEvent{Type: NoteOffEvent, Key: m.Key(), Velocity: m.Velocity()}
+	case channel.ControlChange:
+		switch {
+		case m.Controller() != SustainController:
+			event = InputEvent{Type: ControlEvent}
+		case m.Value() >= 64:
+			event = InputEvent{Type: SustainOnEvent}
+		default:
+			event = InputEvent{Type: SustainOffEvent}
+		}
+	case channel.ProgramChange:
+		event = InputEvent{Type: ControlEvent}
+	default:
+		return nil
+	}
+
+	if onPadChannel(msg) {
+		// Still seen when learning pads
+		event = InputEvent{Type: ControlEvent}
+	}
+
+	event.Message = msg
+	return []InputEvent{event}
+}
+
+// The MIDI message for an event, made up for events which didn't come from
+// MIDI. Pads are sent as their bound messages.
+func EventMessage(event InputEvent) (midi.Message, bool) {
+	if event.Message != nil {
+		return event.Message, true
+	}
+
+	velocity := event.Velocity
+	if velocity == 0 {
+		velocity = KeyboardVelocity
+	}
+
+	switch event.Type {
+	case NoteOnEvent:
+		return channel.Channel0.NoteOn(event.Key, velocity), true
+	case NoteOffEvent:
+		return channel.Channel0.NoteOff(event.Key), true
+	case PadOnEvent, PadOffEvent:
+		if pad, ok := getPad(event.Pad); ok {
+			return pad.Message(event.Type == PadOnEvent)
+		}
+	}
+
+	return nil, false
+}
+
+// A source of notes and pad presses, such as a MIDI controller
+type InputSource interface {
+	// Start sending events to the handler, which must not be called after Stop
+	Start(handle func(InputEvent)) error
+	Stop()
+}
+
+// Events from a MIDI controller, which is reconnected if it's unplugged
+type MidiSource struct {
+	driver *rtmididrv.Driver
+	reader *reader.Reader
+	handle func(InputEvent)
+
+	// Guards the input, which is swapped by the watcher
+	mu sync.Mutex
+	// Nil until a device is found
+	input     midi.In
+	connected bool
+	stop      chan struct{}
+}
+
+func NewMidiSource() (*MidiSource, error) {
+	driver, err := rtmididrv.New()
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := openInput(driver)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	return &MidiSource{driver: driver, input: input, stop: make(chan struct{})}, nil
+}
+
+func (self *MidiSource) Start(handle func(InputEvent)) error {
+	self.handle = handle
+	self.reader = reader.New(
+		reader.NoLogger(),
+		reader.Each(self.onMessage),
+	)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.input != nil {
+		if err := self.reader.ListenTo(self.input); err != nil {
+			return err
+		}
+
+		self.connected = true
+		handle(InputEvent{Type: ConnectedEvent})
+	}
+
+	go self.watch()
+	return nil
+}
+
+func (self *MidiSource) Stop() {
+	close(self.stop)
+
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.input != nil {
+		_ = self.input.StopListening()
+		_ = self.input.Close()
+	}
+	self.driver.Close()
+}
+
+func (self *MidiSource) onMessage(p *reader.Position, msg midi.Message) {
+	for _, event := range ClassifyMidi(msg) {
+		self.handle(event)
+	}
+}
+
+// Watch for the input device being unplugged, and reconnect to it when it
+// returns. When chordy started without a controller, the first one plugged in
+// is used.
+func (self *MidiSource) watch() {
+	for {
+		select {
+		case <-self.stop:
+			return
+		case <-time.After(InputPollInterval):
+		}
+
+		ins, err := self.driver.Ins()
+		if err != nil {
+			continue
+		}
+
+		self.mu.Lock()
+		var event *InputEvent
+		if self.input == nil {
+			if in, err := SelectInput(ins, ""); err == nil && self.connect(in) {
+				event = &InputEvent{Type: ConnectedEvent}
+			}
+		} else {
+			in := findPortByName(ins, self.input.String())
+			if self.connected && in == nil {
+				self.disconnect()
+				event = &InputEvent{Type: DisconnectedEvent}
+			} else if !self.connected && in != nil && self.connect(in) {
+				event = &InputEvent{Type: ConnectedEvent}
+			}
+		}
+		self.mu.Unlock()
+
+		if event != nil {
+			self.handle(*event)
+		}
+	}
+}
+
+func (self *MidiSource) disconnect() {
+	_ = self.input.StopListening()
+	_ = self.input.Close()
+	self.connected = false
+}
+
+// Open a device and send its events to the existing reader, returning whether
+// it worked. Failures are retried on the next poll.
+func (self *MidiSource) connect(in midi.In) bool {
+	if err := in.Open(); err != nil {
+		return false
+	}
+
+	if err := self.reader.ListenTo(in); err != nil {
+		_ = in.Close()
+		return false
+	}
+
+	self.input = in
+	self.connected = true
+	return true
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"time"
+)
+
+// The computer keyboard can be played like a piano, with the home row as the
+// white keys and the row above as the black keys
+var pianoKeys = map[string]uint8{
+	"a": 0, "w": 1, "s": 2, "e": 3, "d": 4, "f": 5, "t": 6,
+	"g": 7, "y": 8, "h": 9, "u": 10, "j": 11, "k": 12,
+}
+
+// Number keys press the first ten pads, in order
+var padKeys = map[string]SelectionKey{"1": 1, "2": 2, "3": 3, "4": 4, "5": 5, "6": 6, "7": 7, "8": 8, "9": 9, "0": 10}
+
+const (
+	DefaultPianoOctave = 4
+	MinPianoOctave     = 1
+	MaxPianoOctave     = 7
+)
+
+const KeyboardVelocity = 100
+
+// How long a note from the computer keyboard sounds for
+const KeyboardNoteLength = 300 * time.Millisecond
+
+// Events from keys pressed in the terminal, which are passed in by the UI loop
+type KeyboardSource struct {
+	octave int
+	handle func(InputEvent)
+}
+
+func NewKeyboardSource() *KeyboardSource {
+	return &KeyboardSource{octave: DefaultPianoOctave}
+}
+
+func (self *KeyboardSource) Start(handle func(InputEvent)) error {
+	self.handle = handle
+	return nil
+}
+
+func (self *KeyboardSource) Stop() {}
+
+// Play a terminal key, returning whether it's one of the piano's keys.
+// Terminals don't report key releases, so each press is released after a
+// short delay.
+func (self *KeyboardSource) Key(id string) bool {
+	switch id {
+	case "z":
+		self.octave = max(MinPianoOctave, self.octave-1)
+		return true
+	case "x":
+		self.octave = min(MaxPianoOctave, self.octave+1)
+		return true
+	}
+
+	if offset, ok := pianoKeys[id]; ok {
+		key := uint8(12*(self.octave+1)) + offset
+		self.handle(InputEvent{Type: NoteOnEvent, Key: key, Velocity: KeyboardVelocity})
+		time.AfterFunc(KeyboardNoteLength, func() {
+			self.handle(InputEvent{Type: NoteOffEvent, Key: key})
+		})
+		return true
+	}
+
+	if pad, ok := padKeys[id]; ok && int(pad) <= len(Pads) {
+		self.handle(InputEvent{Type: PadOnEvent, Pad: pad})
+		time.AfterFunc(KeyboardNoteLength, func() {
+			self.handle(InputEvent{Type: PadOffEvent, Pad: pad})
+		})
+		return true
+	}
+
+	return false
+}
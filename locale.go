@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+)
+
+// Templates for card display names, by language and exercise type. The
+// template is filled in with the card's exercise definition.
+var CardNameTemplates = map[string]map[string]string{
+	"en": {
+		"note":  "%s (note)",
+		"chord": "%s (chord)",
+		"scale": "%s (scale)",
+	},
+	"de": {
+		"note":  "%s (Ton)",
+		"chord": "%s (Akkord)",
+		"scale": "%s (Tonleiter)",
+	},
+}
+
+// Name shown to the user in the configured language. Cards of unknown types
+// fall back to their stored name.
+func (self *Card) DisplayName() string {
+	templates, ok := CardNameTemplates[viper.GetString("Language")]
+	if !ok {
+		templates = CardNameTemplates["en"]
+	}
+
+	template, ok := templates[self.ExerciseType]
+	if !ok {
+		return self.Name
+	}
+
+	return fmt.Sprintf(template, self.ExerciseDefinition)
+}
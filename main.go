@@ -1,65 +1,85 @@
 package main
 
 import (
-	"errors"
+	"flag"
+	"fmt"
 	ui "github.com/gizak/termui/v3"
-	"github.com/gpayer/go-audio-service/generators"
 	"github.com/gpayer/go-audio-service/notes"
-	"github.com/gpayer/go-audio-service/snd"
 	"github.com/spf13/viper"
 	"gitlab.com/gomidi/midi"
-	"gitlab.com/gomidi/midi/reader"
-	"gitlab.com/gomidi/rtmididrv"
 	mt "gopkg.in/music-theory.v0/note"
+	"io"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sync"
+	"time"
 )
 
-// The user can use MIDI controller pads to make selections
+// The user can use MIDI controller pads to make selections. Pads are numbered
+// by their position in Pads and then Pedals, counting from 1.
 type SelectionKey uint8
 
-const (
-	KeyInvalid = iota
-	KeyA
-	KeyB
-	KeyC
-	KeyD
-)
+const KeyInvalid = 0
 
 type SelectionState struct {
 	waiting             bool
-	pressedWhileWaiting []uint8
+	pressedWhileWaiting []SelectionKey
 }
 
-// Application state
-type MidiResources struct {
-	output *snd.Output
-	input  midi.In
-	multi  *notes.NoteMultiplexer
-	driver *rtmididrv.Driver
-}
+type ControllerState uint8
 
-type App struct {
-	db *DB
+const (
+	// No MIDI controller has been found, so only the keyboard can be played
+	ControllerNone ControllerState = iota
+	ControllerConnected
+	ControllerDisconnected
+)
 
-	midi MidiResources
+// Application state
+type App struct {
+	// Guards the state below, which is changed by both MIDI and keyboard events
+	mu sync.Mutex
+
+	db Store
+	// Set if the last automatic backup failed
+	backupErr error
+	// Set when the app can't carry on, e.g. after losing its database, so it
+	// can close the UI before exiting
+	fatalErr error
+	// The current session's input, if it's being recorded
+	recorder     *Recorder
+	recordingErr error
+
+	// Nil when running headless
+	synth      Synth
+	sources    []InputSource
+	keyboard   *KeyboardSource
+	controller ControllerState
+	// Headless apps have no UI, and print a transcript of what happens instead
+	transcript     io.Writer
+	lastTranscript string
 
 	selection SelectionState
 
+	session        SessionOptions
 	state          AppState
+	stateHome      StateHomeArgs
 	stateInSession StateInSessionArgs
+	stateStats     StateStatsArgs
+	stateProfiles  StateProfilesArgs
+	stateLearn     StateLearnArgs
 }
 
 func (a *App) WaitForSelection() {
 	a.selection.waiting = true
-	a.selection.pressedWhileWaiting = []uint8{}
+	a.selection.pressedWhileWaiting = []SelectionKey{}
 }
 
-func (a *App) SelectionReady(key uint8) bool {
+func (a *App) SelectionReady(pad SelectionKey) bool {
 	for _, k := range a.selection.pressedWhileWaiting {
-		if k == key {
+		if k == pad {
 			a.selection.waiting = false
 			return true
 		}
@@ -68,321 +88,736 @@ func (a *App) SelectionReady(key uint8) bool {
 	return false
 }
 
-func getSelectionKey(key uint8) SelectionKey {
-	keyString := strconv.Itoa(int(key))
-
-	switch keyString {
-	case viper.Get("AKey"):
-		return KeyA
-	case viper.Get("BKey"):
-		return KeyB
-	case viper.Get("CKey"):
-		return KeyC
-	case viper.Get("DKey"):
-		return KeyD
-	}
-
-	return KeyInvalid
-}
-
-func isSelectionKey(key uint8) bool {
-	selectionKey := getSelectionKey(key)
-
-	return selectionKey != KeyInvalid
-}
-
 func InitApp() (*App, error) {
-	// Set up output stream
-	output, err := snd.NewOutput(44000, 512)
+	// Set up output, to the built-in synth or a MIDI device
+	synth, err := OpenSynth()
 	if err != nil {
 		return nil, err
 	}
 
-	rect := generators.NewRect(44000, 440.0)
-	multi := notes.NewNoteMultiplexer()
-	multi.SetReadable(rect)
-	output.SetReadable(multi)
-
-	err = output.Start()
+	// Set up input. Without a controller, the computer keyboard is the only
+	// input until one is plugged in.
+	midiSource, err := NewMidiSource()
 	if err != nil {
+		synth.Close()
 		return nil, err
 	}
 
-	// Set up input stream
-	driver, err := rtmididrv.New()
+	app, err := newApp(synth)
 	if err != nil {
+		midiSource.Stop()
+		synth.Close()
 		return nil, err
 	}
 
-	ins, err := driver.Ins()
-	if err != nil {
+	app.keyboard = NewKeyboardSource()
+	if err := app.startSources(midiSource, app.keyboard); err != nil {
+		app.Stop()
 		return nil, err
 	}
 
-	if len(ins) < 2 {
-		return nil, errors.New("no MIDI input device found")
-	}
-
-	input := ins[1]
-	input.Open()
-
-	midi := MidiResources{
-		output: output,
-		input:  input,
-		multi:  multi,
-		driver: driver,
-	}
+	return app, nil
+}
 
-	// Open database
-	db, err := Connect(viper.Get("DatabasePath").(string))
+// Open the database and go to the home screen, ready for input
+func newApp(synth Synth) (*App, error) {
+	db, err := Connect(viper.GetString("DatabasePath"))
 	if err != nil {
 		return nil, err
 	}
 
-	// Create app state
-	app := App{
+	app := &App{
 		db:        db,
-		midi:      midi,
+		synth:     synth,
 		selection: SelectionState{},
-		state:     StateHome,
 	}
+	app.goHome()
 
-	// Set up MIDI event handlers
-	rd := reader.New(
-		reader.NoLogger(),
-		reader.NoteOn(app.onNoteOn),
-		reader.NoteOff(app.onNoteOff),
-	)
+	return app, nil
+}
 
-	err = rd.ListenTo(input)
-	if err != nil {
-		return nil, err
+func (a *App) startSources(sources ...InputSource) error {
+	for _, source := range sources {
+		a.sources = append(a.sources, source)
+		if err := source.Start(a.onInput); err != nil {
+			return err
+		}
 	}
 
-	return &app, nil
+	return nil
 }
 
 func (a *App) Stop() {
-	_ = a.midi.output.Stop()
-	a.midi.driver.Close()
-	a.midi.input.Close()
+	for _, source := range a.sources {
+		source.Stop()
+	}
+
+	if a.synth != nil {
+		a.synth.Close()
+	}
+
+	// Keep the recording of a session which was quit part way through
+	a.mu.Lock()
+	a.saveRecording()
+	a.mu.Unlock()
+
 	a.db.Close()
 }
 
-// Handle MIDI NOTEON events
-func (a *App) onNoteOn(p *reader.Position, channel, key, velocity uint8) {
-	// If waiting for a selection (pad press), store the pressed key
-	// and return - need to wait for the NOTEOFF before proceeding
-	if a.selection.waiting {
-		if isSelectionKey(key) {
-			a.selection.pressedWhileWaiting = append(a.selection.pressedWhileWaiting, key)
+// Move on to the next card in the session, returning home after the last one
+func (a *App) nextExercise() {
+	a.stateInSession.currentIndex++
+
+	if a.stateInSession.currentIndex == len(a.stateInSession.cards) {
+		a.goHome()
+	} else {
+		currentExercise := CreateExercise(a.stateInSession.cards[a.stateInSession.currentIndex])
+		a.stateInSession.state = ExerciseInProgress
+		a.stateInSession.currentExercise = &currentExercise
+		a.stateInSession.showHint = false
+		a.stateInSession.err = nil
+		a.stateInSession.startedAt = time.Now()
+		a.markExercise()
+	}
+}
+
+// Note the start of the current exercise in the recording, starting a new
+// recording if there isn't one yet
+func (a *App) markExercise() {
+	if a.recorder == nil {
+		if !viper.GetBool("RecordSessions") {
+			return
 		}
+		a.recorder = NewRecorder(time.Now())
+	}
+
+	s := a.stateInSession
+	a.recorder.Mark(fmt.Sprintf("%d/%d %s", s.currentIndex+1, len(s.cards), s.cards[s.currentIndex].ID))
+}
+
+// Write the session's recording to the recordings directory
+func (a *App) saveRecording() {
+	if a.recorder == nil {
 		return
 	}
 
-	// Play the pressed note
-	note := notes.MidiToNote(int64(key))
-	a.midi.multi.SendNoteEvent(notes.NewNoteEvent(notes.Pressed, note, float32(velocity)/127))
+	name := a.recorder.start.Format(recordingTimeFormat) + ".mid"
+	a.recordingErr = a.recorder.Save(filepath.Join(RecordingDir(viper.GetString("DatabasePath")), name))
+	a.recorder = nil
+}
 
-	// Process event according to the current state
-	switch a.state {
-	case StateHome:
-		cardsForThisSession, err := a.db.GetCardsForToday()
+// Return to the home screen, refreshing the practice history it shows
+func (a *App) goHome() {
+	a.state = StateHome
+	a.saveRecording()
+
+	now := time.Now()
+	reviews, err := a.db.Reviews(startOfDay(now).AddDate(0, 0, -PracticeHistoryDays))
+	if err != nil {
+		return
+	}
+
+	practice := PracticeByDay(reviews, now, PracticeHistoryDays)
+	a.stateHome = StateHomeArgs{
+		practice: practice,
+		streak:   Streak(practice),
+	}
+}
+
+// Record the result of the current exercise, unless cramming without rescheduling
+func (a *App) grade(difficulty uint) {
+	card := a.stateInSession.cards[a.stateInSession.currentIndex]
+
+	duration := time.Since(a.stateInSession.startedAt)
+	if duration > MaxReviewDuration {
+		duration = MaxReviewDuration
+	}
+
+	// Cram reviews count as practice without touching the schedule
+	if !a.stateInSession.reschedule {
+		review := NewReview(card, difficulty, time.Now())
+		review.Duration = duration
+		review.Cram = true
+		a.db.AddReview(review)
+
+		a.pushUndo(card, false, review.ID)
+		return
+	}
+
+	updatedCard := RecalculateCard(card, difficulty)
+	review := NewReview(card, difficulty, updatedCard.LastRecalledAt)
+	review.Duration = duration
+	a.db.UpsertWithReview(updatedCard, review)
+
+	a.pushUndo(card, true, review.ID)
+}
 
-		if err != nil {
-			panic(err) // This should never happen
+// Remember the card as it was before the current exercise changed it
+func (a *App) pushUndo(card Card, written bool, reviewID string) {
+	a.stateInSession.undo = append(a.stateInSession.undo, UndoEntry{
+		index:    a.stateInSession.currentIndex,
+		card:     card,
+		written:  written,
+		reviewID: reviewID,
+	})
+}
+
+// Restore the card changed by the last grade (or bury/suspend) and step the
+// session back to its exercise, even if the session had already finished
+func (a *App) undo() {
+	if a.state != StateInSession && a.state != StateHome {
+		return
+	}
+
+	n := len(a.stateInSession.undo)
+	if n == 0 {
+		return
+	}
+
+	entry := a.stateInSession.undo[n-1]
+
+	if entry.written {
+		if err := a.db.Upsert(entry.card); err != nil {
+			return
 		}
+	}
 
-		if len(cardsForThisSession) == 0 {
+	if entry.reviewID != "" {
+		if err := a.db.DeleteReview(entry.reviewID); err != nil {
 			return
 		}
+	}
 
-		currentExercise := CreateExercise(cardsForThisSession[0])
+	a.stateInSession.undo = a.stateInSession.undo[:n-1]
+
+	currentExercise := CreateExercise(entry.card)
+	a.state = StateInSession
+	a.stateInSession.currentIndex = entry.index
+	a.stateInSession.currentExercise = &currentExercise
+	a.stateInSession.state = ExerciseInProgress
+	a.stateInSession.showHint = false
+	a.stateInSession.err = nil
+	a.stateInSession.startedAt = time.Now()
+	a.selection.waiting = false
+	a.markExercise()
+}
 
-		a.state = StateInSession
-		a.stateInSession = StateInSessionArgs{
-			cards:           cardsForThisSession,
-			currentIndex:    0,
-			currentExercise: &currentExercise,
-			state:           ExerciseInProgress,
-		}
+// Take the current card out of rotation without grading it, either until
+// tomorrow (bury) or until it is unsuspended from the command line
+func (a *App) setAsideCurrentCard(suspend bool) {
+	if a.state != StateInSession || a.stateInSession.state != ExerciseInProgress {
+		return
+	}
 
-	case StateInSession:
-		// Check pads first
-		if getSelectionKey(key) == KeyA {
-			a.stateInSession.state = ExerciseFail
-		} else if getSelectionKey(key) == KeyB {
-			a.stateInSession.showHint = true
+	original := a.stateInSession.cards[a.stateInSession.currentIndex]
+	card := original
+	now := time.Now()
+	if suspend {
+		card.Suspended = true
+	} else {
+		card.Bury(now)
+	}
+	card.ChangedAt = now
+
+	if err := a.db.Upsert(card); err != nil {
+		a.stateInSession.err = err
+		return
+	}
+
+	a.pushUndo(original, true, "")
+
+	a.nextExercise()
+}
+
+// Switch to the statistics screen, or back to where the user was
+func (a *App) toggleStats() {
+	if a.state == StateStats {
+		if a.stateStats.returnState == StateHome {
+			a.goHome()
 		} else {
-			var noteClass mt.Class
-			switch key % 12 {
-			case 0:
-				noteClass = mt.C
-			case 1:
-				noteClass = mt.Cs
-			case 2:
-				noteClass = mt.D
-			case 3:
-				noteClass = mt.Ds
-			case 4:
-				noteClass = mt.E
-			case 5:
-				noteClass = mt.F
-			case 6:
-				noteClass = mt.Fs
-			case 7:
-				noteClass = mt.G
-			case 8:
-				noteClass = mt.Gs
-			case 9:
-				noteClass = mt.A
-			case 10:
-				noteClass = mt.As
-			case 11:
-				noteClass = mt.B
-			}
+			a.state = a.stateStats.returnState
+			// A finished exercise is still waiting for a pad
+			a.waitIfFinished()
+		}
+		return
+	}
 
-			exerciseState := a.stateInSession.currentExercise.Progress(noteClass)
-			a.stateInSession.state = exerciseState
+	cards, err := a.db.AllCards()
+	if err != nil {
+		return
+	}
+
+	reviews, err := a.db.Reviews(time.Time{})
+	if err != nil {
+		return
+	}
+
+	now := time.Now()
+	forecast, err := a.db.DueForecast(now, ForecastDays)
+	if err != nil {
+		return
+	}
+
+	a.stateStats = StateStatsArgs{
+		stats:       ComputeStats(cards, reviews, forecast, now),
+		returnState: a.state,
+	}
+	a.state = StateStats
+}
+
+// Show the profile picker, with the current profile selected
+func (a *App) openProfiles() {
+	profiles, err := ListProfiles()
+	if err != nil {
+		return
+	}
+
+	a.stateProfiles = StateProfilesArgs{profiles: profiles}
+	for i, profile := range profiles {
+		if profile == CurrentProfile {
+			a.stateProfiles.selected = i
 		}
+	}
+
+	a.state = StateProfiles
+}
+
+func (a *App) moveProfileSelection(by int) {
+	n := len(a.stateProfiles.profiles)
+	a.stateProfiles.selected = (a.stateProfiles.selected + by + n) % n
+}
+
+// Close the current profile's database and open another profile's. If the new
+// profile can't be opened, the previous one is reopened.
+func (a *App) switchProfile(name string) error {
+	if name == CurrentProfile {
+		return nil
+	}
 
-		switch a.stateInSession.state {
-		case ExerciseFail:
-			a.WaitForSelection()
-		case ExercisePass:
-			a.WaitForSelection()
+	previous := CurrentProfile
+	a.db.Close()
+
+	err := LoadProfile(name)
+	if err == nil {
+		var db Store
+		db, err = Connect(viper.GetString("DatabasePath"))
+		if err == nil {
+			a.db = db
+			a.backupErr = nil
+			// Undo entries refer to the previous profile's cards
+			a.stateInSession.undo = nil
+			return nil
 		}
 	}
 
-	RenderUI(a)
+	if restoreErr := LoadProfile(previous); restoreErr != nil {
+		a.fatalErr = fmt.Errorf("could not reload profile %s: %v", previous, restoreErr)
+		return a.fatalErr
+	}
+
+	db, restoreErr := Connect(viper.GetString("DatabasePath"))
+	if restoreErr != nil {
+		a.fatalErr = fmt.Errorf("could not reopen database for profile %s: %v", previous, restoreErr)
+		return a.fatalErr
+	}
+	a.db = db
+
+	return err
+}
+
+// Show the screen which asks for each pad to be pressed in turn
+func (a *App) openLearn() {
+	if len(Pads) == 0 {
+		return
+	}
+
+	a.stateLearn = StateLearnArgs{}
+	a.state = StateLearn
 }
 
-func (a *App) onNoteOff(p *reader.Position, channel, key, velocity uint8) {
-	note := notes.MidiToNote(int64(key))
-	a.midi.multi.SendNoteEvent(notes.NewNoteEvent(notes.Released, note, float32(velocity)/127))
+// Bind the next pad to the message which pressed it, saving the bindings once
+// every pad has been pressed
+func (a *App) learnPad(msg midi.Message) {
+	binding, ok := LearnBinding(msg)
+	if !ok {
+		return
+	}
 
-	switch a.state {
-	case StateInSession:
-		switch a.stateInSession.state {
-		case ExerciseFail:
-			if a.SelectionReady(key) {
-				if getSelectionKey(key) == KeyA {
-					a.stateInSession.currentExercise.Reset()
-					a.stateInSession.state = ExerciseInProgress
-				} else {
-					updatedCard := RecalculateCard(a.stateInSession.cards[a.stateInSession.currentIndex], 0)
-					a.db.Upsert(updatedCard)
-
-					a.stateInSession.currentIndex++
-
-					if a.stateInSession.currentIndex == len(a.stateInSession.cards) {
-						a.state = StateHome
-					} else {
-						currentExercise := CreateExercise(a.stateInSession.cards[a.stateInSession.currentIndex])
-						a.stateInSession.state = ExerciseInProgress
-						a.stateInSession.currentExercise = &currentExercise
-						a.stateInSession.showHint = false
-					}
-				}
+	for i, learned := range a.stateLearn.learned {
+		if learned == binding {
+			a.stateLearn.err = fmt.Errorf("%s is already pad %s", binding, padName(SelectionKey(i+1)))
+			return
+		}
+	}
+
+	a.stateLearn.err = nil
+	a.stateLearn.learned = append(a.stateLearn.learned, binding)
+	if len(a.stateLearn.learned) < len(Pads) {
+		return
+	}
+
+	if err := savePadBindings(a.stateLearn.learned); err != nil {
+		a.stateLearn = StateLearnArgs{err: fmt.Errorf("could not save pads: %v", err)}
+		return
+	}
+
+	a.goHome()
+}
+
+// Snapshot the database into the backups directory
+func (a *App) backup() {
+	_, a.backupErr = BackupStore(a.db, viper.GetString("DatabasePath"), viper.GetInt("BackupCount"))
+}
 
+// Back up the database whenever the last backup is older than the backup
+// interval, for as long as the app is running
+func (a *App) scheduleBackups() {
+	for {
+		a.mu.Lock()
+		if BackupDue(a.db, time.Now()) {
+			a.backup()
+			if a.state == StateHome {
+				RenderUI(a)
 			}
-		case ExercisePass:
-			// Wait for the user to make a pad selection
-			if a.SelectionReady(key) {
-				// Based on the pad pressed, determine the difficulty level of the exercise
-				selectionKey := getSelectionKey(key)
-				var difficulty uint
-				switch selectionKey {
-				case KeyA:
-					difficulty = 3
-				case KeyB:
-					difficulty = 4
-				case KeyC:
-					difficulty = 5
-				case KeyD:
-					difficulty = 5
-				}
-
-				updatedCard := RecalculateCard(a.stateInSession.cards[a.stateInSession.currentIndex], difficulty)
-
-				a.db.Upsert(updatedCard)
-
-				a.stateInSession.currentIndex++
-
-				if a.stateInSession.currentIndex == len(a.stateInSession.cards) {
-					a.state = StateHome
-				} else {
-					currentExercise := CreateExercise(a.stateInSession.cards[a.stateInSession.currentIndex])
-					a.stateInSession.state = ExerciseInProgress
-					a.stateInSession.currentExercise = &currentExercise
-					a.stateInSession.showHint = false
-				}
+		}
+		a.mu.Unlock()
+
+		time.Sleep(time.Hour)
+	}
+}
+
+// Handle keyboard shortcuts from the terminal, and notes played on the
+// keyboard piano
+func (a *App) onKey(id string) {
+	// Keys which mean something else on the profile picker aren't played, and
+	// nothing is played while learning pads
+	a.mu.Lock()
+	picking := a.state == StateProfiles || a.state == StateLearn
+	a.mu.Unlock()
+
+	if !picking && a.keyboard.Key(id) {
+		return
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.state == StateProfiles {
+		switch id {
+		case "<Up>", "k":
+			a.moveProfileSelection(-1)
+		case "<Down>", "j":
+			a.moveProfileSelection(1)
+		case "<Enter>":
+			a.stateProfiles.err = a.switchProfile(a.stateProfiles.profiles[a.stateProfiles.selected])
+			if a.stateProfiles.err == nil {
+				a.goHome()
 			}
+		case "<Escape>", "p":
+			a.goHome()
+		}
+
+		RenderUI(a)
+		return
+	}
+
+	if a.state == StateLearn {
+		if id == "<Escape>" || id == "l" {
+			a.goHome()
+		}
+
+		RenderUI(a)
+		return
+	}
+
+	switch id {
+	case "p":
+		if a.state == StateHome {
+			a.openProfiles()
 		}
+	case "l":
+		if a.state == StateHome {
+			a.openLearn()
+		}
+	case "S":
+		a.setAsideCurrentCard(true)
+	case "B":
+		a.setAsideCurrentCard(false)
+	case "<Backspace>", "<C-z>":
+		a.undo()
+	case "<Tab>":
+		a.toggleStats()
 	}
 
 	RenderUI(a)
 }
 
-func main() {
-	home, err := os.UserHomeDir()
+// Handle an event from any input source
+func (a *App) onInput(event InputEvent) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	recorder := a.recorder
+	if recorder != nil {
+		recorder.Record(event)
+	}
+	defer func() {
+		// Include the event which started a session
+		if recorder == nil && a.recorder != nil {
+			a.recorder.Record(event)
+		}
+	}()
+
+	if a.state == StateLearn && event.Message != nil {
+		a.learnPad(event.Message)
+		RenderUI(a)
+		return
+	}
+
+	switch event.Type {
+	case NoteOnEvent:
+		a.onNoteOn(event.Key, event.Velocity)
+	case NoteOffEvent:
+		a.onNoteOff(event.Key, event.Velocity)
+	case PadOnEvent:
+		a.onPadOn(event.Pad)
+	case PadOffEvent:
+		a.onPadOff(event.Pad)
+	case SustainOnEvent:
+		a.sustainNotes(true)
+	case SustainOffEvent:
+		a.sustainNotes(false)
+	case ConnectedEvent:
+		a.controller = ControllerConnected
+	case DisconnectedEvent:
+		a.controller = ControllerDisconnected
+		// Pad releases for presses before the disconnection won't arrive
+		a.selection.pressedWhileWaiting = []SelectionKey{}
+	}
+
+	RenderUI(a)
+}
+
+// Play or release a note on the synth
+func (a *App) sendNote(kind int, key, velocity uint8) {
+	if a.synth == nil {
+		return
+	}
+
+	if kind == notes.Pressed {
+		a.synth.NoteOn(key, velocity)
+	} else {
+		a.synth.NoteOff(key)
+	}
+}
+
+func (a *App) sustainNotes(on bool) {
+	if a.synth != nil {
+		a.synth.Sustain(on)
+	}
+}
+
+func (a *App) startSession() {
+	cardsForThisSession, err := a.session.Cards(a.db)
+
 	if err != nil {
-		log.Fatalf("could not find user home directory: %v", err)
+		a.stateHome.err = err
+		return
+	}
+
+	if len(cardsForThisSession) == 0 {
+		return
 	}
 
-	configPath := filepath.Join(home, "/.config/chordy")
-	defaultDataPath := filepath.Join(home, "/.data/chordy")
+	a.backup()
+
+	currentExercise := CreateExercise(cardsForThisSession[0])
+
+	a.state = StateInSession
+	a.stateInSession = StateInSessionArgs{
+		cards:           cardsForThisSession,
+		currentIndex:    0,
+		currentExercise: &currentExercise,
+		state:           ExerciseInProgress,
+		reschedule:      a.session.Reschedule,
+		startedAt:       time.Now(),
+	}
+	a.markExercise()
+}
 
-	if err = os.MkdirAll(configPath, os.ModePerm); err != nil {
-		log.Fatalf("could not create config directory: %v", err)
+// Once an exercise is passed or failed, wait for a pad to say what happens next
+func (a *App) waitIfFinished() {
+	if a.state != StateInSession {
+		return
 	}
 
-	if err = os.MkdirAll(defaultDataPath, os.ModePerm); err != nil {
-		log.Fatalf("could not create config directory: %v", err)
+	switch a.stateInSession.state {
+	case ExerciseFail:
+		a.WaitForSelection()
+	case ExercisePass:
+		a.WaitForSelection()
 	}
+}
 
-	viper.SetDefault("DatabasePath", filepath.Join(defaultDataPath, "db"))
-	viper.SetDefault("AKey", "40")
-	viper.SetDefault("BKey", "41")
-	viper.SetDefault("CKey", "42")
-	viper.SetDefault("DKey", "43")
-	viper.SetConfigName("config.json")
-	viper.AddConfigPath(configPath)
+func (a *App) onNoteOn(key, velocity uint8) {
+	// Notes are ignored while waiting for a pad selection
+	if a.selection.waiting {
+		return
+	}
 
-	if err := viper.ReadInConfig(); err != nil {
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+	// Play the pressed note
+	a.sendNote(notes.Pressed, key, velocity)
 
-			if err = viper.WriteConfigAs(filepath.Join(configPath, "config.json")); err != nil {
-				log.Fatalf("could not write default config file: %v", err)
-			}
-		} else {
-			log.Fatalf("could not read config file: %v", err)
+	// Process event according to the current state
+	switch a.state {
+	case StateHome:
+		a.startSession()
+
+	case StateInSession:
+		var noteClass mt.Class
+		switch key % 12 {
+		case 0:
+			noteClass = mt.C
+		case 1:
+			noteClass = mt.Cs
+		case 2:
+			noteClass = mt.D
+		case 3:
+			noteClass = mt.Ds
+		case 4:
+			noteClass = mt.E
+		case 5:
+			noteClass = mt.F
+		case 6:
+			noteClass = mt.Fs
+		case 7:
+			noteClass = mt.G
+		case 8:
+			noteClass = mt.Gs
+		case 9:
+			noteClass = mt.A
+		case 10:
+			noteClass = mt.As
+		case 11:
+			noteClass = mt.B
 		}
+
+		exerciseState := a.stateInSession.currentExercise.Progress(noteClass)
+		a.stateInSession.state = exerciseState
+		a.waitIfFinished()
+	}
+}
+
+func (a *App) onNoteOff(key, velocity uint8) {
+	a.sendNote(notes.Released, key, velocity)
+}
+
+func (a *App) onPadOn(pad SelectionKey) {
+	// If waiting for a selection, store the pressed pad and return - need to
+	// wait for its release before proceeding
+	if a.selection.waiting {
+		a.selection.pressedWhileWaiting = append(a.selection.pressedWhileWaiting, pad)
+		return
+	}
+
+	if p, ok := getPad(pad); ok {
+		a.doAction(p.Action)
+		a.waitIfFinished()
 	}
+}
 
+func (a *App) onPadOff(pad SelectionKey) {
+	// Once an exercise is finished, pads act when they're released
+	if !a.selection.waiting || !a.SelectionReady(pad) {
+		return
+	}
+
+	if p, ok := getPad(pad); ok {
+		a.doAction(p.Action)
+	}
+
+	// Pads which did nothing, or left the exercise finished, don't count as
+	// a selection
+	if a.state == StateInSession && a.stateInSession.state != ExerciseInProgress {
+		a.selection.waiting = true
+	}
+}
+
+// Run the interactive app until the user quits
+func RunApp(session SessionOptions) error {
 	app, err := InitApp()
 
 	if err != nil {
-		log.Fatalf("could not initialize app: %v", err)
+		return fmt.Errorf("could not initialize app: %v", err)
 	}
 
 	defer app.Stop()
 
+	app.session = session
+
 	if err := InitUI(); err != nil {
-		log.Fatalf("could not initialize ui: %v", err)
+		return fmt.Errorf("could not initialize ui: %v", err)
 	}
 
 	defer CloseUI()
 
 	RenderUI(app)
 
+	go app.scheduleBackups()
+
 	for e := range ui.PollEvents() {
 		switch e.ID {
 		case "q", "<C-c>":
-			return
+			return nil
 		case "<Resize>":
 			ClearUI()
 			RenderUI(app)
+		default:
+			app.onKey(e.ID)
+			if app.fatalErr != nil {
+				return app.fatalErr
+			}
 		}
 	}
+
+	return nil
+}
+
+func main() {
+	profile := flag.String("profile", DefaultProfile, "name of the profile to use, which is created if it doesn't exist")
+	input := flag.String("input", "", "MIDI input to use, by index or part of its name (see chordy devices)")
+	output := flag.String("output", "", "MIDI output to play notes on instead of the built-in synth")
+	flag.Usage = PrintUsage
+	flag.Parse()
+
+	rand.Seed(time.Now().UnixNano())
+
+	if err := LoadProfile(*profile); err != nil {
+		log.Fatal(err)
+	}
+
+	if *input != "" {
+		OverrideSetting("MidiInput", *input)
+	}
+
+	if *output != "" {
+		OverrideSetting("MidiOutput", *output)
+	}
+
+	if flag.NArg() > 0 {
+		command, ok := FindCommand(flag.Arg(0))
+		if !ok {
+			PrintUsage()
+			os.Exit(2)
+		}
+
+		if err := command.Run(flag.Args()[1:]); err != nil && err != flag.ErrHelp {
+			log.Fatalf("%s: %v", command.Name, err)
+		}
+		return
+	}
+
+	if err := RunApp(SessionOptions{Reschedule: true}); err != nil {
+		log.Fatal(err)
+	}
 }
@@ -0,0 +1,288 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"strconv"
+)
+
+// The schema version is stored in the migrations bucket under this key
+var SchemaVersionKey = []byte("version")
+
+// Databases created before versioned migrations only recorded that the default
+// cards had been added, which corresponds to version 1
+var legacyDefaultsKey = []byte("defaults")
+
+// A change to the database applied once, in order of version, within the same
+// transaction as every other pending migration. Migrations must never be
+// reordered or removed once released; new ones are appended with the next version.
+type Migration struct {
+	Version     int
+	Description string
+	Apply       func(tx *bolt.Tx, report *MigrationReport) error
+}
+
+var Migrations = []Migration{
+	{Version: 1, Description: "add default cards", Apply: AddMissingDefaultCards},
+	{Version: 2, Description: "key cards by stable ID instead of name", Apply: assignCardIDs},
+	{Version: 3, Description: "index cards by due date", Apply: rebuildDueIndex},
+	{Version: 4, Description: "index buried cards by the end of their burial", Apply: rebuildDueIndex},
+}
+
+// What a migration run did, or would do in a dry run
+type MigrationReport struct {
+	From    int
+	To      int
+	Applied []string
+	Changes []string
+	// Keys of cards which couldn't be read, and were left as they are
+	Unreadable []string
+}
+
+func (self *MigrationReport) Logf(format string, args ...interface{}) {
+	self.Changes = append(self.Changes, fmt.Sprintf(format, args...))
+}
+
+// Note a card which a migration couldn't read and skipped
+func (self *MigrationReport) skipCard(key []byte, err error) {
+	self.Logf("skipped unreadable card %q: %v", key, err)
+
+	for _, k := range self.Unreadable {
+		if k == string(key) {
+			return
+		}
+	}
+	self.Unreadable = append(self.Unreadable, string(key))
+}
+
+func (self *MigrationReport) String() string {
+	if self.From == self.To {
+		return fmt.Sprintf("database is up to date (version %d)\n", self.To)
+	}
+
+	s := fmt.Sprintf("migrating from version %d to %d\n", self.From, self.To)
+	for _, applied := range self.Applied {
+		s += fmt.Sprintf("  %s\n", applied)
+	}
+	for _, change := range self.Changes {
+		s += fmt.Sprintf("    %s\n", change)
+	}
+	if len(self.Unreadable) > 0 {
+		s += fmt.Sprintf("%d cards couldn't be read; run chordy doctor to quarantine them\n", len(self.Unreadable))
+	}
+
+	return s
+}
+
+func LatestSchemaVersion() int {
+	return Migrations[len(Migrations)-1].Version
+}
+
+func schemaVersion(tx *bolt.Tx) (int, error) {
+	b := tx.Bucket(MigrationBucket)
+
+	v := b.Get(SchemaVersionKey)
+	if v == nil {
+		if b.Get(legacyDefaultsKey) != nil {
+			return 1, nil
+		}
+		return 0, nil
+	}
+
+	return strconv.Atoi(string(v))
+}
+
+func migrate(tx *bolt.Tx, report *MigrationReport) error {
+	version, err := schemaVersion(tx)
+	if err != nil {
+		return err
+	}
+
+	report.From = version
+	report.To = version
+
+	if version > LatestSchemaVersion() {
+		return fmt.Errorf("database version %d is newer than this version of chordy supports (%d)", version, LatestSchemaVersion())
+	}
+
+	for _, migration := range Migrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		report.Applied = append(report.Applied, fmt.Sprintf("%d: %s", migration.Version, migration.Description))
+		if err := migration.Apply(tx, report); err != nil {
+			return fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+		}
+
+		report.To = migration.Version
+	}
+
+	if report.To == report.From {
+		return nil
+	}
+
+	return tx.Bucket(MigrationBucket).Put(SchemaVersionKey, []byte(strconv.Itoa(report.To)))
+}
+
+var errDryRun = errors.New("dry run")
+
+// Apply all pending migrations in one transaction. In a dry run, the
+// transaction is rolled back and only the report is returned.
+func (self *BoltStore) Migrate(dryRun bool) (MigrationReport, error) {
+	var report MigrationReport
+
+	err := self.db.Update(func(tx *bolt.Tx) error {
+		if err := migrate(tx, &report); err != nil {
+			return err
+		}
+
+		if dryRun {
+			return errDryRun
+		}
+
+		return nil
+	})
+
+	if err == errDryRun {
+		err = nil
+	}
+
+	return report, err
+}
+
+// Add any default cards the database doesn't have yet. A migration using this
+// should be appended whenever new cards are added to DefaultCards.
+func AddMissingDefaultCards(tx *bolt.Tx, report *MigrationReport) error {
+	b := tx.Bucket(CardBucket)
+
+	added := 0
+	for _, card := range DefaultCards() {
+		if b.Get(card.Key()) != nil {
+			continue
+		}
+
+		if err := putCard(tx, card); err != nil {
+			return err
+		}
+
+		added++
+	}
+
+	report.Logf("added %d default cards", added)
+	return nil
+}
+
+// Move cards to new keys, keeping their progress. The moves map each card's
+// current key to the card as it should be stored. Reviews refer to cards by
+// key, so they are updated to match.
+func RekeyCards(tx *bolt.Tx, report *MigrationReport, moves map[string]Card) error {
+	cards := tx.Bucket(CardBucket)
+
+	for oldKey := range moves {
+		if err := deleteCard(tx, []byte(oldKey)); err != nil {
+			return err
+		}
+	}
+
+	for oldKey, card := range moves {
+		if cards.Get(card.Key()) != nil {
+			return fmt.Errorf("cannot move card %q to %q: key already in use", oldKey, card.Key())
+		}
+
+		if err := putCard(tx, card); err != nil {
+			return err
+		}
+	}
+
+	reviews := tx.Bucket(ReviewBucket)
+	updated := map[string][]byte{}
+	err := reviews.ForEach(func(k, v []byte) error {
+		review, err := DeserializeReview(v)
+		if err != nil {
+			return err
+		}
+
+		card, ok := moves[review.CardKey]
+		if !ok {
+			return nil
+		}
+
+		review.CardKey = string(card.Key())
+		r, err := review.Serialize()
+		if err != nil {
+			return err
+		}
+
+		updated[string(k)] = r
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for k, r := range updated {
+		if err := reviews.Put([]byte(k), r); err != nil {
+			return err
+		}
+	}
+
+	report.Logf("moved %d cards and %d reviews to new keys", len(moves), len(updated))
+	return nil
+}
+
+// Rewrite every card for which update returns true, e.g. to fill in a new field
+func BackfillCards(tx *bolt.Tx, report *MigrationReport, update func(card *Card) bool) error {
+	changed := []Card{}
+	err := tx.Bucket(CardBucket).ForEach(func(k, v []byte) error {
+		card, err := DeserializeCard(v)
+		if err != nil {
+			report.skipCard(k, err)
+			return nil
+		}
+
+		if update(&card) {
+			changed = append(changed, card)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, card := range changed {
+		if err := putCard(tx, card); err != nil {
+			return err
+		}
+	}
+
+	report.Logf("updated %d cards", len(changed))
+	return nil
+}
+
+// Cards were originally keyed by their display name
+func assignCardIDs(tx *bolt.Tx, report *MigrationReport) error {
+	moves := map[string]Card{}
+
+	err := tx.Bucket(CardBucket).ForEach(func(k, v []byte) error {
+		card, err := DeserializeCard(v)
+		if err != nil {
+			report.skipCard(k, err)
+			return nil
+		}
+
+		if card.ID == "" {
+			card.ID = CardID(card.ExerciseType, card.ExerciseDefinition)
+			moves[string(k)] = card
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return RekeyCards(tx, report, moves)
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"time"
+)
+
+// Number of days of practice history shown on the home screen
+const PracticeHistoryDays = 52 * 7
+
+// Time spent on a single exercise is capped, so walking away from the
+// keyboard mid-exercise doesn't inflate the day's practice time
+const MaxReviewDuration = 5 * time.Minute
+
+type PracticeDay struct {
+	Date     time.Time
+	Reviews  int
+	Duration time.Duration
+}
+
+// Total practice on each of the given number of days, oldest first and ending today
+func PracticeByDay(reviews []Review, now time.Time, days int) []PracticeDay {
+	practice := make([]PracticeDay, days)
+	today := startOfDay(now)
+
+	for i := range practice {
+		practice[i].Date = today.AddDate(0, 0, i-days+1)
+	}
+
+	for _, review := range reviews {
+		day := days - 1 - daysBetween(review.ReviewedAt, now)
+		if day < 0 || day >= days {
+			continue
+		}
+
+		practice[day].Reviews++
+		practice[day].Duration += review.Duration
+	}
+
+	return practice
+}
+
+// Number of consecutive days with at least one review, ending today. A streak
+// isn't broken until the end of today, so it may also end yesterday.
+func Streak(practice []PracticeDay) int {
+	end := len(practice) - 1
+	if end >= 0 && practice[end].Reviews == 0 {
+		end--
+	}
+
+	streak := 0
+	for i := end; i >= 0 && practice[i].Reviews > 0; i-- {
+		streak++
+	}
+
+	return streak
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"time"
+)
+
+// Selects a subset of cards, e.g. for bulk suspension or tagging. Empty fields
+// match every card, and comma-separated values match any of the listed values.
+type CardFilter struct {
+	Type  string
+	Root  string
+	Form  string
+	Tag   string
+	Match string
+	// Only match cards failed at a review within this many days
+	FailedDays int
+
+	// Keys of the cards failed within FailedDays, read by Load
+	failed map[string]bool
+}
+
+// Register the filter's fields as flags on a command's flag set
+func (self *CardFilter) AddFlags(flags *flag.FlagSet) {
+	flags.StringVar(&self.Type, "type", "", "exercise type (note, chord, scale)")
+	flags.StringVar(&self.Root, "root", "", "root note, e.g. Eb")
+	flags.StringVar(&self.Form, "form", "", "chord or scale form, e.g. maj or dor,phr,lyd")
+	flags.StringVar(&self.Tag, "tag", "", "card tag")
+	flags.StringVar(&self.Match, "match", "", "text contained in the card name")
+	flags.IntVar(&self.FailedDays, "failed-days", 0, "cards failed in the last N days")
+}
+
+// Whether no filters were given, so every card matches
+func (self *CardFilter) IsEmpty() bool {
+	return self.Type == "" && self.Root == "" && self.Form == "" && self.Tag == "" && self.Match == "" &&
+		self.FailedDays == 0
+}
+
+// Read the review history the filter needs. This must be called before Matches
+// when FailedDays is set.
+func (self *CardFilter) Load(db Store) error {
+	if self.FailedDays <= 0 {
+		return nil
+	}
+
+	reviews, err := db.Reviews(time.Now().AddDate(0, 0, -self.FailedDays))
+	if err != nil {
+		return err
+	}
+
+	self.failed = map[string]bool{}
+	for _, review := range reviews {
+		if !review.Passed() {
+			self.failed[review.CardKey] = true
+		}
+	}
+
+	return nil
+}
+
+func (self *CardFilter) Matches(card Card) bool {
+	root, form := SplitDefinition(card.ExerciseDefinition)
+
+	if !matchesAny(self.Type, card.ExerciseType) {
+		return false
+	}
+
+	if !matchesAny(self.Root, root) {
+		return false
+	}
+
+	if card.ExerciseType != "note" && !matchesAny(self.Form, form) {
+		return false
+	}
+
+	if card.ExerciseType == "note" && self.Form != "" {
+		return false
+	}
+
+	if self.Tag != "" {
+		tagged := false
+		for _, tag := range strings.Split(self.Tag, ",") {
+			if card.HasTag(tag) {
+				tagged = true
+			}
+		}
+
+		if !tagged {
+			return false
+		}
+	}
+
+	if self.Match != "" && !strings.Contains(strings.ToLower(card.DisplayName()), strings.ToLower(self.Match)) {
+		return false
+	}
+
+	if self.FailedDays > 0 && !self.failed[string(card.Key())] {
+		return false
+	}
+
+	return true
+}
+
+func matchesAny(options, value string) bool {
+	if options == "" {
+		return true
+	}
+
+	for _, option := range strings.Split(options, ",") {
+		if strings.TrimSpace(option) == value {
+			return true
+		}
+	}
+
+	return false
+}
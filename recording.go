@@ -0,0 +1,134 @@
+package main
+
+import (
+	"gitlab.com/gomidi/midi"
+	"gitlab.com/gomidi/midi/reader"
+	"gitlab.com/gomidi/midi/smf"
+	"gitlab.com/gomidi/midi/writer"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const recordingTimeFormat = "20060102-150405"
+
+// Recordings are written at 120 beats per minute with this many ticks per
+// beat, so there are twice as many ticks per second
+const (
+	recordingBPM            = 120
+	recordingResolution     = 960
+	recordingTicksPerSecond = recordingResolution * recordingBPM / 60
+)
+
+func RecordingDir(dbPath string) string {
+	return filepath.Join(filepath.Dir(dbPath), "recordings")
+}
+
+func isSMFPath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".mid" || ext == ".midi"
+}
+
+type recordedMessage struct {
+	at      time.Duration
+	message midi.Message
+	// Set instead of the message for markers
+	marker string
+}
+
+// Collects a session's input so it can be saved as a Standard MIDI File, with
+// a marker at the start of each exercise
+type Recorder struct {
+	start    time.Time
+	messages []recordedMessage
+}
+
+func NewRecorder(start time.Time) *Recorder {
+	return &Recorder{start: start}
+}
+
+func (self *Recorder) Record(event InputEvent) {
+	if message, ok := EventMessage(event); ok {
+		self.messages = append(self.messages, recordedMessage{at: time.Since(self.start), message: message})
+	}
+}
+
+func (self *Recorder) Mark(text string) {
+	self.messages = append(self.messages, recordedMessage{at: time.Since(self.start), marker: text})
+}
+
+func (self *Recorder) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	return writeFileAtomically(path, self.WriteSMF)
+}
+
+func (self *Recorder) WriteSMF(w io.Writer) error {
+	wr := writer.NewSMF(w, 1)
+	// Record exactly what was played, even notes which never stopped
+	wr.ConsolidateNotes(false)
+
+	if err := writer.TempoBPM(wr, recordingBPM); err != nil {
+		return err
+	}
+
+	var last uint64
+	for _, recorded := range self.messages {
+		ticks := uint64(recorded.at.Seconds() * recordingTicksPerSecond)
+		wr.SetDelta(uint32(ticks - last))
+		last = ticks
+
+		var err error
+		if recorded.message != nil {
+			err = wr.Write(recorded.message)
+		} else {
+			err = writer.Marker(wr, recorded.marker)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	// The writer reports that the file is complete as an error
+	if err := writer.EndOfTrack(wr); err != nil && err != smf.ErrFinished {
+		return err
+	}
+
+	return nil
+}
+
+// Read the input recorded in a Standard MIDI File, for replaying. Pads are
+// recognised by the current pad settings.
+func ReadReplaySMF(path string) ([]ReplayEvent, error) {
+	events := []ReplayEvent{}
+
+	var rd *reader.Reader
+	rd = reader.New(
+		reader.NoLogger(),
+		reader.Each(func(p *reader.Position, msg midi.Message) {
+			if p == nil {
+				return
+			}
+
+			at := reader.TimeAt(rd, p.AbsoluteTicks)
+			if at == nil {
+				return
+			}
+
+			for _, event := range ClassifyMidi(msg) {
+				events = append(events, ReplayEvent{At: *at, Event: event})
+			}
+		}),
+	)
+
+	if err := reader.ReadSMFFile(rd, path); err != nil && err != smf.ErrFinished {
+		return nil, err
+	}
+
+	return events, nil
+}
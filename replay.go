@@ -0,0 +1,216 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// An input event at a time relative to the start of a replay
+type ReplayEvent struct {
+	At    time.Duration
+	Event InputEvent
+}
+
+var replayEventNames = map[string]InputEventType{
+	"note-on":  NoteOnEvent,
+	"note-off": NoteOffEvent,
+	"pad-on":   PadOnEvent,
+	"pad-off":  PadOffEvent,
+}
+
+// Read a replay file, which has one event per line: the time in seconds, the
+// event, and its MIDI key (for notes, optionally followed by a velocity) or
+// pad (by letter). Blank lines and lines starting with # are ignored.
+//
+//	0.0  note-on   60  100
+//	0.3  note-off  60
+//	1.5  pad-on    B
+//	1.6  pad-off   B
+func ReadReplay(r io.Reader) ([]ReplayEvent, error) {
+	events := []ReplayEvent{}
+
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		event, err := parseReplayEvent(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", line, err)
+		}
+
+		events = append(events, event)
+	}
+
+	return events, scanner.Err()
+}
+
+func parseReplayEvent(fields []string) (ReplayEvent, error) {
+	if len(fields) < 3 || len(fields) > 4 {
+		return ReplayEvent{}, fmt.Errorf("expected time, event and value, got %q", strings.Join(fields, " "))
+	}
+
+	seconds, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil || seconds < 0 {
+		return ReplayEvent{}, fmt.Errorf("invalid time %q", fields[0])
+	}
+
+	eventType, ok := replayEventNames[fields[1]]
+	if !ok {
+		return ReplayEvent{}, fmt.Errorf("unknown event %q (expected note-on, note-off, pad-on or pad-off)", fields[1])
+	}
+
+	event := InputEvent{Type: eventType}
+	if eventType == PadOnEvent || eventType == PadOffEvent {
+		if event.Pad, ok = parsePadName(fields[2]); !ok || len(fields) > 3 {
+			return ReplayEvent{}, fmt.Errorf("invalid pad %q (expected A to %s)", strings.Join(fields[2:], " "), padName(SelectionKey(len(Pads))))
+		}
+	} else {
+		key, err := strconv.ParseUint(fields[2], 10, 7)
+		if err != nil {
+			return ReplayEvent{}, fmt.Errorf("invalid MIDI key %q", fields[2])
+		}
+		event.Key = uint8(key)
+
+		if len(fields) == 4 {
+			velocity, err := strconv.ParseUint(fields[3], 10, 7)
+			if err != nil {
+				return ReplayEvent{}, fmt.Errorf("invalid velocity %q", fields[3])
+			}
+			event.Velocity = uint8(velocity)
+		} else if eventType == NoteOnEvent {
+			event.Velocity = KeyboardVelocity
+		}
+	}
+
+	return ReplayEvent{At: time.Duration(seconds * float64(time.Second)), Event: event}, nil
+}
+
+// Plays back recorded events, e.g. to drive a session without a controller
+type ReplaySource struct {
+	events []ReplayEvent
+	// Multiple of real time to play at, or 0 to send every event at once
+	speed float64
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func NewReplaySource(events []ReplayEvent, speed float64) *ReplaySource {
+	return &ReplaySource{events: events, speed: speed, stop: make(chan struct{}), done: make(chan struct{})}
+}
+
+func (self *ReplaySource) Start(handle func(InputEvent)) error {
+	go func() {
+		defer close(self.done)
+
+		start := time.Now()
+		for _, event := range self.events {
+			if self.speed > 0 {
+				at := start.Add(time.Duration(float64(event.At) / self.speed))
+				select {
+				case <-self.stop:
+					return
+				case <-time.After(time.Until(at)):
+				}
+			}
+
+			select {
+			case <-self.stop:
+				return
+			default:
+			}
+
+			handle(event.Event)
+		}
+	}()
+
+	return nil
+}
+
+func (self *ReplaySource) Stop() {
+	select {
+	case <-self.stop:
+	default:
+		close(self.stop)
+	}
+
+	<-self.done
+}
+
+// Closed once every event has been sent
+func (self *ReplaySource) Done() <-chan struct{} {
+	return self.done
+}
+
+// Run a session without a UI, driven by a replay. Each change of screen or
+// exercise state is written to the transcript.
+func RunHeadless(session SessionOptions, source *ReplaySource, transcript io.Writer) error {
+	app, err := newApp(nil)
+	if err != nil {
+		return err
+	}
+	defer app.Stop()
+
+	app.session = session
+	app.transcript = transcript
+	RenderUI(app)
+
+	if err := app.startSources(source); err != nil {
+		return err
+	}
+
+	<-source.Done()
+	return nil
+}
+
+// Write a line describing the app's state, if it has changed since the last one
+func (a *App) writeTranscript() {
+	line := a.describe()
+	if line == a.lastTranscript {
+		return
+	}
+
+	fmt.Fprintln(a.transcript, line)
+	a.lastTranscript = line
+}
+
+func (a *App) describe() string {
+	switch a.state {
+	case StateHome:
+		if a.stateHome.err != nil {
+			return fmt.Sprintf("home (could not start a session: %v)", a.stateHome.err)
+		}
+		return "home"
+	case StateStats:
+		return "stats"
+	case StateProfiles:
+		return "profiles"
+	case StateLearn:
+		return fmt.Sprintf("learn pad %s", padName(SelectionKey(len(a.stateLearn.learned)+1)))
+	}
+
+	s := a.stateInSession
+	card := s.cards[s.currentIndex]
+	line := fmt.Sprintf("card %d/%d %s: ", s.currentIndex+1, len(s.cards), card.DisplayName())
+
+	switch s.state {
+	case ExerciseInProgress:
+		line += fmt.Sprintf("step %d/%d", s.currentExercise.CurrentStep+1, len(s.currentExercise.Definition.Parts))
+	case ExerciseFail:
+		line += "failed"
+	case ExercisePass:
+		line += "passed"
+	}
+
+	if s.showHint {
+		line += " (hint shown)"
+	}
+
+	return line
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"bytes"
+	"github.com/spf13/viper"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+	"time"
+)
+
+// Load the default settings, with the database in a scratch directory
+func useScratchSettings(t *testing.T) {
+	t.Helper()
+
+	viper.Reset()
+	setConfigDefaults(t.TempDir())
+	viper.Set("RecordSessions", false)
+
+	if err := loadPads(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunHeadless(t *testing.T) {
+	useScratchSettings(t)
+
+	events, err := readReplayFile("testdata/replay.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want, err := ioutil.ReadFile("testdata/replay.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rand.Seed(1)
+	var transcript bytes.Buffer
+	if err := RunHeadless(SessionOptions{Reschedule: true}, NewReplaySource(events, 0), &transcript); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := transcript.String(); got != string(want) {
+		t.Errorf("transcript differs from testdata/replay.golden:\n%s", got)
+	}
+
+	db, err := Connect(viper.GetString("DatabasePath"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	reviews, err := db.Reviews(time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grades := []uint{}
+	for _, review := range reviews {
+		grades = append(grades, review.Grade)
+	}
+	if len(grades) != 2 || grades[0] != 4 || grades[1] != 0 {
+		t.Errorf("replay recorded grades %v, want [4 0]", grades)
+	}
+}
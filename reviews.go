@@ -0,0 +1,136 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/boltdb/bolt"
+	"time"
+)
+
+var ReviewBucket = []byte("reviews")
+
+// A single graded exercise. Reviews are kept alongside the cards so progress
+// can be analysed over time.
+type Review struct {
+	// Sorts by review time, with a random suffix to keep IDs unique
+	ID         string
+	CardKey    string
+	Grade      uint
+	ReviewedAt time.Time
+	// Time spent on the exercise
+	Duration time.Duration
+
+	// The card's schedule before this review
+	Recalls  uint
+	Ef       float32
+	Interval uint
+
+	// Made in a cram session, so it counts as practice but didn't change the
+	// card's schedule
+	Cram bool
+}
+
+func reviewIDPrefix(t time.Time) string {
+	return fmt.Sprintf("%019d", t.UnixNano())
+}
+
+func NewReview(card Card, grade uint, at time.Time) Review {
+	suffix := make([]byte, 4)
+	_, _ = rand.Read(suffix)
+
+	return Review{
+		ID:         reviewIDPrefix(at) + "-" + hex.EncodeToString(suffix),
+		CardKey:    string(card.Key()),
+		Grade:      grade,
+		ReviewedAt: at,
+		Recalls:    card.Recalls,
+		Ef:         card.Ef,
+		Interval:   card.Interval,
+	}
+}
+
+func (self *Review) Key() []byte {
+	return []byte(self.ID)
+}
+
+// Whether the exercise was recalled successfully
+func (self *Review) Passed() bool {
+	return self.Grade >= 3
+}
+
+func (self *Review) Serialize() ([]byte, error) {
+	return json.Marshal(self)
+}
+
+func DeserializeReview(data []byte) (Review, error) {
+	var review Review
+	err := json.Unmarshal(data, &review)
+	return review, err
+}
+
+// Save a graded card together with the review that graded it
+func (self *BoltStore) UpsertWithReview(card Card, review Review) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		if err := putCard(tx, card); err != nil {
+			return err
+		}
+
+		r, err := review.Serialize()
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(ReviewBucket).Put(review.Key(), r)
+	})
+}
+
+// Save a review which didn't change its card
+func (self *BoltStore) AddReview(review Review) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		r, err := review.Serialize()
+		if err != nil {
+			return err
+		}
+
+		return tx.Bucket(ReviewBucket).Put(review.Key(), r)
+	})
+}
+
+func (self *BoltStore) DeleteReview(id string) error {
+	return self.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(ReviewBucket).Delete([]byte(id))
+	})
+}
+
+// Read all reviews made at or after the given time, oldest first
+func (self *BoltStore) Reviews(since time.Time) ([]Review, error) {
+	reviews := []Review{}
+
+	err := self.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(ReviewBucket).Cursor()
+
+		var start []byte
+		if !since.IsZero() {
+			start = []byte(reviewIDPrefix(since))
+		}
+
+		for k, v := c.Seek(start); k != nil; k, v = c.Next() {
+			review, err := DeserializeReview(v)
+			if err != nil {
+				return err
+			}
+
+			reviews = append(reviews, review)
+		}
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return reviews, nil
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"math/rand"
+)
+
+// Determines which cards make up a session and whether grading them affects
+// their schedule
+type SessionOptions struct {
+	// When set, the session is built from every matching card regardless of
+	// whether it is due ("cram" mode)
+	Filter *CardFilter
+	// Maximum number of cards in a filtered session, or 0 for no limit
+	Limit      int
+	Reschedule bool
+}
+
+func (self *SessionOptions) Cards(db Store) ([]Card, error) {
+	if self.Filter == nil {
+		return GetCardsForToday(db)
+	}
+
+	if err := self.Filter.Load(db); err != nil {
+		return nil, err
+	}
+
+	cards, err := db.AllCards()
+	if err != nil {
+		return nil, err
+	}
+
+	selected := []Card{}
+	for _, card := range cards {
+		if !card.Suspended && isDeckEnabled(card.ExerciseType) && self.Filter.Matches(card) {
+			selected = append(selected, card)
+		}
+	}
+
+	rand.Shuffle(len(selected), func(a, b int) {
+		selected[a], selected[b] = selected[b], selected[a]
+	})
+
+	if self.Limit > 0 {
+		selected = selected[:min(self.Limit, len(selected))]
+	}
+
+	return selected, nil
+}
@@ -0,0 +1,611 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Name of the database/sql driver used for SQLite databases, registered by
+// sqlite_driver.go when building with -tags sqlite
+const SQLiteDriver = "sqlite3"
+
+// Times are stored as fixed-width UTC text so they sort correctly and can be
+// used with SQLite's date functions
+const sqliteTimeFormat = "2006-01-02T15:04:05.000000000Z"
+
+const sqliteCardColumns = "id, name, exercise_type, exercise_definition, recalls, ef, interval, last_recalled_at, suspended, buried_until, tags, changed_at"
+const sqliteReviewColumns = "id, card_id, grade, reviewed_at, duration_seconds, recalls, ef, interval, cram"
+
+// A change to the SQLite schema, tracked with PRAGMA user_version. These are
+// independent of the bolt migrations, which mostly fix up data that a
+// converted SQLite database already has in its current form.
+type SQLiteMigration struct {
+	Version     int
+	Description string
+	Apply       func(tx *sql.Tx, report *MigrationReport) error
+}
+
+var SQLiteMigrations = []SQLiteMigration{
+	{Version: 1, Description: "create tables and add default cards", Apply: createSQLiteSchema},
+	{Version: 2, Description: "record cram reviews", Apply: addSQLiteCramColumn},
+	{Version: 3, Description: "record when cards were suspended, buried or tagged", Apply: addSQLiteChangedAtColumn},
+}
+
+// Store implementation backed by an SQLite database, so practice history can
+// be queried with SQL
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+func ConnectSQLite(path string) (*SQLiteStore, error) {
+	store, err := ConnectSQLiteWithoutMigrating(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := store.Migrate(false); err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	return store, nil
+}
+
+func ConnectSQLiteWithoutMigrating(path string) (*SQLiteStore, error) {
+	registered := false
+	for _, driver := range sql.Drivers() {
+		if driver == SQLiteDriver {
+			registered = true
+		}
+	}
+
+	if !registered {
+		return nil, errors.New("this build of chordy doesn't support SQLite databases (rebuild with -tags sqlite)")
+	}
+
+	db, err := sql.Open(SQLiteDriver, path)
+	if err != nil {
+		return nil, err
+	}
+
+	// SQLite allows a single writer, so serialise access through one connection
+	db.SetMaxOpenConns(1)
+
+	if err := db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+func (self *SQLiteStore) Close() {
+	self.db.Close()
+}
+
+func createSQLiteSchema(tx *sql.Tx, report *MigrationReport) error {
+	statements := []string{
+		`CREATE TABLE cards (
+			id TEXT PRIMARY KEY,
+			name TEXT NOT NULL,
+			exercise_type TEXT NOT NULL,
+			exercise_definition TEXT NOT NULL,
+			recalls INTEGER NOT NULL,
+			ef REAL NOT NULL,
+			interval INTEGER NOT NULL,
+			last_recalled_at TEXT,
+			next_recall_at TEXT NOT NULL,
+			suspended INTEGER NOT NULL,
+			buried_until TEXT,
+			tags TEXT NOT NULL
+		)`,
+		`CREATE INDEX cards_next_recall_at ON cards (next_recall_at)`,
+		`CREATE TABLE reviews (
+			id TEXT PRIMARY KEY,
+			card_id TEXT NOT NULL,
+			grade INTEGER NOT NULL,
+			reviewed_at TEXT NOT NULL,
+			duration_seconds REAL NOT NULL,
+			recalls INTEGER NOT NULL,
+			ef REAL NOT NULL,
+			interval INTEGER NOT NULL
+		)`,
+		`CREATE INDEX reviews_reviewed_at ON reviews (reviewed_at)`,
+		`CREATE INDEX reviews_card_id ON reviews (card_id)`,
+		`CREATE TABLE settings (
+			key TEXT PRIMARY KEY,
+			value TEXT NOT NULL
+		)`,
+	}
+
+	for _, statement := range statements {
+		if _, err := tx.Exec(statement); err != nil {
+			return err
+		}
+	}
+
+	added := 0
+	for _, card := range DefaultCards() {
+		if err := sqliteUpsertCard(tx, card); err != nil {
+			return err
+		}
+		added++
+	}
+
+	report.Logf("added %d default cards", added)
+	return nil
+}
+
+func addSQLiteCramColumn(tx *sql.Tx, report *MigrationReport) error {
+	_, err := tx.Exec("ALTER TABLE reviews ADD COLUMN cram INTEGER NOT NULL DEFAULT 0")
+	return err
+}
+
+func addSQLiteChangedAtColumn(tx *sql.Tx, report *MigrationReport) error {
+	if _, err := tx.Exec("ALTER TABLE cards ADD COLUMN changed_at TEXT"); err != nil {
+		return err
+	}
+
+	// Quarantined cards are copied row for row, so their table needs the column too
+	var quarantine int
+	if err := tx.QueryRow("SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'quarantined_cards'").Scan(&quarantine); err != nil {
+		return err
+	}
+	if quarantine == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec("ALTER TABLE quarantined_cards ADD COLUMN changed_at TEXT")
+	return err
+}
+
+func (self *SQLiteStore) Migrate(dryRun bool) (MigrationReport, error) {
+	var report MigrationReport
+
+	tx, err := self.db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return report, err
+	}
+
+	latest := SQLiteMigrations[len(SQLiteMigrations)-1].Version
+	if version > latest {
+		return report, fmt.Errorf("database version %d is newer than this version of chordy supports (%d)", version, latest)
+	}
+
+	report.From = version
+	report.To = version
+
+	for _, migration := range SQLiteMigrations {
+		if migration.Version <= version {
+			continue
+		}
+
+		report.Applied = append(report.Applied, fmt.Sprintf("%d: %s", migration.Version, migration.Description))
+		if err := migration.Apply(tx, &report); err != nil {
+			return report, fmt.Errorf("migration %d (%s) failed: %v", migration.Version, migration.Description, err)
+		}
+
+		report.To = migration.Version
+	}
+
+	if dryRun || report.To == report.From {
+		return report, nil
+	}
+
+	// PRAGMA doesn't accept bound parameters
+	if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", report.To)); err != nil {
+		return report, err
+	}
+
+	return report, tx.Commit()
+}
+
+func formatSQLiteTime(t time.Time) sql.NullString {
+	if t.IsZero() {
+		return sql.NullString{}
+	}
+
+	return sql.NullString{String: t.UTC().Format(sqliteTimeFormat), Valid: true}
+}
+
+func parseSQLiteTime(s sql.NullString) (time.Time, error) {
+	if !s.Valid {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(sqliteTimeFormat, s.String)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return t.Local(), nil
+}
+
+// Either *sql.Row or *sql.Rows
+type sqlScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanSQLiteCard(row sqlScanner) (Card, error) {
+	var card Card
+	var lastRecalledAt, buriedUntil, changedAt sql.NullString
+	var tags string
+
+	err := row.Scan(&card.ID, &card.Name, &card.ExerciseType, &card.ExerciseDefinition,
+		&card.Recalls, &card.Ef, &card.Interval, &lastRecalledAt, &card.Suspended, &buriedUntil, &tags, &changedAt)
+	if err != nil {
+		return card, err
+	}
+
+	if card.ChangedAt, err = parseSQLiteTime(changedAt); err != nil {
+		return card, err
+	}
+
+	if card.LastRecalledAt, err = parseSQLiteTime(lastRecalledAt); err != nil {
+		return card, err
+	}
+
+	if card.BuriedUntil, err = parseSQLiteTime(buriedUntil); err != nil {
+		return card, err
+	}
+
+	err = json.Unmarshal([]byte(tags), &card.Tags)
+	return card, err
+}
+
+func scanSQLiteReview(row sqlScanner) (Review, error) {
+	var review Review
+	var reviewedAt sql.NullString
+	var duration float64
+
+	err := row.Scan(&review.ID, &review.CardKey, &review.Grade, &reviewedAt, &duration,
+		&review.Recalls, &review.Ef, &review.Interval, &review.Cram)
+	if err != nil {
+		return review, err
+	}
+
+	review.Duration = time.Duration(duration * float64(time.Second))
+	review.ReviewedAt, err = parseSQLiteTime(reviewedAt)
+	return review, err
+}
+
+// Either *sql.DB or *sql.Tx
+type sqlExecer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+func sqliteUpsertCard(db sqlExecer, card Card) error {
+	tags, err := json.Marshal(card.Tags)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("INSERT OR REPLACE INTO cards ("+sqliteCardColumns+", next_recall_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		card.ID, card.Name, card.ExerciseType, card.ExerciseDefinition,
+		card.Recalls, card.Ef, card.Interval, formatSQLiteTime(card.LastRecalledAt),
+		card.Suspended, formatSQLiteTime(card.BuriedUntil), string(tags), formatSQLiteTime(card.ChangedAt),
+		NextRecallTime(card).UTC().Format(sqliteTimeFormat))
+	return err
+}
+
+// Insert a review unless one with the same ID exists, returning whether it was added
+func sqliteInsertReview(db sqlExecer, review Review) (bool, error) {
+	result, err := db.Exec("INSERT OR IGNORE INTO reviews ("+sqliteReviewColumns+") VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		review.ID, review.CardKey, review.Grade, formatSQLiteTime(review.ReviewedAt),
+		review.Duration.Seconds(), review.Recalls, review.Ef, review.Interval, review.Cram)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+func (self *SQLiteStore) queryCards(query string, args ...interface{}) ([]Card, error) {
+	rows, err := self.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	cards := []Card{}
+	for rows.Next() {
+		card, err := scanSQLiteCard(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		cards = append(cards, card)
+	}
+
+	return cards, rows.Err()
+}
+
+func (self *SQLiteStore) AllCards() ([]Card, error) {
+	return self.queryCards("SELECT " + sqliteCardColumns + " FROM cards ORDER BY name")
+}
+
+func (self *SQLiteStore) DueCards(now time.Time) ([]Card, error) {
+	t := now.UTC().Format(sqliteTimeFormat)
+	return self.queryCards("SELECT "+sqliteCardColumns+` FROM cards
+		WHERE suspended = 0 AND (buried_until IS NULL OR buried_until <= ?) AND next_recall_at < ?
+		ORDER BY next_recall_at`, t, t)
+}
+
+func (self *SQLiteStore) DueForecast(now time.Time, days int) ([]int, error) {
+	forecast := make([]int, days)
+	end := startOfDay(now).AddDate(0, 0, days).UTC().Format(sqliteTimeFormat)
+
+	// Buried cards are due once their burial ends, as with the bolt store's
+	// index. Times are stored in a fixed-width format, so they compare as text.
+	rows, err := self.db.Query(`SELECT MAX(next_recall_at, COALESCE(buried_until, next_recall_at)) AS due FROM cards
+		WHERE suspended = 0 AND due < ?`, end)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var due sql.NullString
+		if err := rows.Scan(&due); err != nil {
+			return nil, err
+		}
+
+		t, err := parseSQLiteTime(due)
+		if err != nil {
+			return nil, err
+		}
+
+		if day := max(0, daysBetween(now, t)); day < days {
+			forecast[day]++
+		}
+	}
+
+	return forecast, rows.Err()
+}
+
+func (self *SQLiteStore) Upsert(card Card) error {
+	return sqliteUpsertCard(self.db, card)
+}
+
+func (self *SQLiteStore) DeleteCard(key string) error {
+	_, err := self.db.Exec("DELETE FROM cards WHERE id = ?", key)
+	return err
+}
+
+func (self *SQLiteStore) UpdateCards(filter func(Card) bool, update func(*Card)) (int, error) {
+	cards, err := self.AllCards()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := self.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	updated := 0
+	for _, card := range cards {
+		if !filter(card) {
+			continue
+		}
+
+		update(&card)
+		if err := sqliteUpsertCard(tx, card); err != nil {
+			return 0, err
+		}
+
+		updated++
+	}
+
+	return updated, tx.Commit()
+}
+
+func (self *SQLiteStore) ScanCards(f func(key string, card Card, err error) error) error {
+	rows, err := self.db.Query("SELECT id FROM cards ORDER BY id")
+	if err != nil {
+		return err
+	}
+
+	// Read the keys first, so a card which can't be scanned doesn't stop the others
+	keys := []string{}
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			rows.Close()
+			return err
+		}
+		keys = append(keys, key)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, key := range keys {
+		card, err := scanSQLiteCard(self.db.QueryRow("SELECT "+sqliteCardColumns+" FROM cards WHERE id = ?", key))
+		if err := f(key, card, err); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Quarantined cards are moved to a table with the same columns as cards
+func (self *SQLiteStore) QuarantineCard(key string) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec("CREATE TABLE IF NOT EXISTS quarantined_cards AS SELECT * FROM cards WHERE 0"); err != nil {
+		return err
+	}
+
+	result, err := tx.Exec("INSERT INTO quarantined_cards SELECT * FROM cards WHERE id = ?", key)
+	if err != nil {
+		return err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("no card with key %q", key)
+	}
+
+	if _, err := tx.Exec("DELETE FROM cards WHERE id = ?", key); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (self *SQLiteStore) UpsertWithReview(card Card, review Review) error {
+	tx, err := self.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := sqliteUpsertCard(tx, card); err != nil {
+		return err
+	}
+
+	if _, err := sqliteInsertReview(tx, review); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (self *SQLiteStore) AddReview(review Review) error {
+	_, err := sqliteInsertReview(self.db, review)
+	return err
+}
+
+func (self *SQLiteStore) DeleteReview(id string) error {
+	_, err := self.db.Exec("DELETE FROM reviews WHERE id = ?", id)
+	return err
+}
+
+func (self *SQLiteStore) Reviews(since time.Time) ([]Review, error) {
+	rows, err := self.db.Query("SELECT "+sqliteReviewColumns+" FROM reviews WHERE reviewed_at >= ? ORDER BY id",
+		since.UTC().Format(sqliteTimeFormat))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	reviews := []Review{}
+	for rows.Next() {
+		review, err := scanSQLiteReview(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		reviews = append(reviews, review)
+	}
+
+	return reviews, rows.Err()
+}
+
+func (self *SQLiteStore) Setting(key string) (string, error) {
+	var value string
+
+	err := self.db.QueryRow("SELECT value FROM settings WHERE key = ?", key).Scan(&value)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return value, err
+}
+
+func (self *SQLiteStore) SetSetting(key, value string) error {
+	_, err := self.db.Exec("INSERT OR REPLACE INTO settings (key, value) VALUES (?, ?)", key, value)
+	return err
+}
+
+func (self *SQLiteStore) Settings() (map[string]string, error) {
+	rows, err := self.db.Query("SELECT key, value FROM settings")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	settings := map[string]string{}
+	for rows.Next() {
+		var key, value string
+		if err := rows.Scan(&key, &value); err != nil {
+			return nil, err
+		}
+
+		settings[key] = value
+	}
+
+	return settings, rows.Err()
+}
+
+func (self *SQLiteStore) Import(data Export, mode MergeMode) (ImportReport, error) {
+	var report ImportReport
+
+	if err := checkExportVersion(data); err != nil {
+		return report, err
+	}
+
+	tx, err := self.db.Begin()
+	if err != nil {
+		return report, err
+	}
+	defer tx.Rollback()
+
+	for _, card := range data.Cards {
+		if card.ID == "" {
+			card.ID = CardID(card.ExerciseType, card.ExerciseDefinition)
+		}
+
+		existing, err := scanSQLiteCard(tx.QueryRow("SELECT "+sqliteCardColumns+" FROM cards WHERE id = ?", card.ID))
+		if err == nil {
+			if !shouldReplace(existing, card, mode) {
+				report.Skipped++
+				continue
+			}
+
+			report.Updated++
+		} else if err == sql.ErrNoRows {
+			report.Added++
+		} else {
+			return report, err
+		}
+
+		if err := sqliteUpsertCard(tx, card); err != nil {
+			return report, err
+		}
+	}
+
+	for _, review := range data.Reviews {
+		added, err := sqliteInsertReview(tx, review)
+		if err != nil {
+			return report, err
+		}
+
+		if added {
+			report.ReviewsAdded++
+		} else {
+			report.ReviewsExisting++
+		}
+	}
+
+	return report, tx.Commit()
+}
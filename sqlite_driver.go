@@ -0,0 +1,8 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// The SQLite driver needs cgo and a C toolchain, so it's only included in
+// builds made with -tags sqlite
+import _ "github.com/mattn/go-sqlite3"
@@ -5,6 +5,7 @@ import (
 	"time"
 )
 
+// Default number of cards in a session, which profiles can change
 const MaxItemsPerDay = 10
 
 func NextRecallTime(card Card) time.Time {
@@ -13,7 +14,12 @@ func NextRecallTime(card Card) time.Time {
 
 // Implements the SuperMemo SM-2 algorithm
 func RecalculateCard(card Card, difficulty uint) Card {
-	card.LastRecalledAt = time.Now()
+	return RecalculateCardAt(card, difficulty, time.Now())
+}
+
+// Grade a card as if it was recalled at the given time, e.g. when replaying reviews
+func RecalculateCardAt(card Card, difficulty uint, at time.Time) Card {
+	card.LastRecalledAt = at
 
 	if difficulty >= 3 {
 		if card.Recalls == 0 {
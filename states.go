@@ -1,11 +1,18 @@
 package main
 
+import (
+	"time"
+)
+
 type AppState uint8
 
 // Application is modelled as a simple state machine
 const (
 	StateHome = iota
 	StateInSession
+	StateStats
+	StateProfiles
+	StateLearn
 )
 
 type ExerciseState uint8
@@ -16,7 +23,32 @@ const (
 	ExercisePass
 )
 
-type StateHomeArgs struct{}
+type StateHomeArgs struct {
+	practice []PracticeDay
+	streak   int
+	// Why the last session couldn't start, if it didn't
+	err error
+}
+
+type StateStatsArgs struct {
+	stats Stats
+	// State to go back to when the stats screen is closed
+	returnState AppState
+}
+
+type StateProfilesArgs struct {
+	profiles []string
+	selected int
+	// Why the last switch failed, if it did
+	err error
+}
+
+type StateLearnArgs struct {
+	// Bindings for the pads pressed so far, in order
+	learned []PadBinding
+	// Why the last press wasn't learned, or the bindings couldn't be saved
+	err error
+}
 
 type StateInSessionArgs struct {
 	cards           []Card
@@ -24,4 +56,18 @@ type StateInSessionArgs struct {
 	currentExercise *Exercise
 	state           ExerciseState
 	showHint        bool
+	reschedule      bool
+	startedAt       time.Time
+	// Cards changed during the session, most recent last
+	undo []UndoEntry
+	// Why the last action on the current card failed, if it did
+	err error
+}
+
+// Snapshot of a card taken before an exercise graded, buried or suspended it
+type UndoEntry struct {
+	index    int
+	card     Card
+	written  bool
+	reviewID string
 }
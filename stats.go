@@ -0,0 +1,130 @@
+package main
+
+import (
+	"math"
+	"time"
+)
+
+const ForecastDays = 30
+const HistoryDays = 30
+
+// Cards with an interval of at least this many days are considered mature
+const MatureInterval = 21
+
+// Reviews of learned cards grouped by the interval they were reviewed at
+type RetentionBucket struct {
+	Label       string
+	MaxInterval uint
+	Reviews     int
+	Passed      int
+}
+
+func (self *RetentionBucket) Percent() float64 {
+	if self.Reviews == 0 {
+		return 0
+	}
+
+	return 100 * float64(self.Passed) / float64(self.Reviews)
+}
+
+type EfBucket struct {
+	Label string
+	MaxEf float32
+	Cards int
+}
+
+type Stats struct {
+	// Cards due on each of the next days, starting today (including overdue cards)
+	DueForecast []int
+	Retention   []RetentionBucket
+	EfHistogram []EfBucket
+
+	New       int
+	Learning  int
+	Mature    int
+	Suspended int
+
+	// Reviews on each of the last days, ending today
+	ReviewsPerDay []int
+}
+
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// Number of calendar days from a to b
+func daysBetween(a, b time.Time) int {
+	return int(math.Round(startOfDay(b).Sub(startOfDay(a)).Hours() / 24))
+}
+
+// Compute statistics from all cards and reviews, along with a forecast of due
+// cards which is read from the store's index
+func ComputeStats(cards []Card, reviews []Review, forecast []int, now time.Time) Stats {
+	stats := Stats{
+		DueForecast: forecast,
+		Retention: []RetentionBucket{
+			{Label: "1d", MaxInterval: 1},
+			{Label: "2-6d", MaxInterval: 6},
+			{Label: "7-20d", MaxInterval: 20},
+			{Label: "21-89d", MaxInterval: 89},
+			{Label: "90d+", MaxInterval: math.MaxUint32},
+		},
+		EfHistogram: []EfBucket{
+			{Label: "1.3", MaxEf: 1.5},
+			{Label: "1.5", MaxEf: 1.7},
+			{Label: "1.7", MaxEf: 1.9},
+			{Label: "1.9", MaxEf: 2.1},
+			{Label: "2.1", MaxEf: 2.3},
+			{Label: "2.3", MaxEf: 2.5},
+			{Label: "2.5+", MaxEf: math.MaxFloat32},
+		},
+		ReviewsPerDay: make([]int, HistoryDays),
+	}
+
+	for _, card := range cards {
+		if card.Suspended {
+			stats.Suspended++
+			continue
+		}
+
+		if card.LastRecalledAt.IsZero() {
+			stats.New++
+		} else if card.Interval < MatureInterval {
+			stats.Learning++
+		} else {
+			stats.Mature++
+		}
+
+		for i := range stats.EfHistogram {
+			if card.Ef < stats.EfHistogram[i].MaxEf {
+				stats.EfHistogram[i].Cards++
+				break
+			}
+		}
+	}
+
+	for _, review := range reviews {
+		day := HistoryDays - 1 - daysBetween(review.ReviewedAt, now)
+		if day >= 0 && day < HistoryDays {
+			stats.ReviewsPerDay[day]++
+		}
+
+		// Only scheduled reviews of learned cards count towards retention
+		if review.Recalls == 0 || review.Cram {
+			continue
+		}
+
+		for i := range stats.Retention {
+			if review.Interval <= stats.Retention[i].MaxInterval {
+				stats.Retention[i].Reviews++
+				if review.Passed() {
+					stats.Retention[i].Passed++
+				}
+				break
+			}
+		}
+	}
+
+	return stats
+}
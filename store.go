@@ -0,0 +1,211 @@
+package main
+
+import (
+	"fmt"
+	"github.com/spf13/viper"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Persistent storage for cards, their review history and settings
+type Store interface {
+	// Cards
+	AllCards() ([]Card, error)
+	Upsert(card Card) error
+	// Apply an update to every card matching the filter in a single transaction,
+	// returning the number of cards changed
+	UpdateCards(filter func(Card) bool, update func(*Card)) (int, error)
+	DeleteCard(key string) error
+
+	// Reviews
+	UpsertWithReview(card Card, review Review) error
+	AddReview(review Review) error
+	DeleteReview(id string) error
+	Reviews(since time.Time) ([]Review, error)
+
+	// Settings
+	Setting(key string) (string, error)
+	SetSetting(key, value string) error
+	Settings() (map[string]string, error)
+
+	// Queries
+	DueCards(now time.Time) ([]Card, error)
+	// Count the unsuspended cards due on each of the given number of days,
+	// starting today. Overdue cards are counted as due today, and buried cards
+	// on the day their burial ends.
+	DueForecast(now time.Time, days int) ([]int, error)
+
+	// Visit every card, including ones which can't be read, which are passed
+	// along with the error from reading them
+	ScanCards(f func(key string, card Card, err error) error) error
+	// Move a card into quarantine, where it's kept but never used
+	QuarantineCard(key string) error
+
+	// Write a consistent snapshot of the database to a file
+	Backup(path string) error
+	Migrate(dryRun bool) (MigrationReport, error)
+	Import(data Export, mode MergeMode) (ImportReport, error)
+	Close()
+}
+
+// Database files with these extensions are opened with SQLite, and anything
+// else with bolt
+var SQLiteExtensions = []string{".sqlite", ".sqlite3", ".db3"}
+
+func isSQLitePath(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range SQLiteExtensions {
+		if ext == e {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Open the database at the given path, applying any pending migrations
+func Connect(path string) (Store, error) {
+	if isSQLitePath(path) {
+		return ConnectSQLite(path)
+	}
+
+	return ConnectBolt(path)
+}
+
+// Open the database at the given path, leaving pending migrations unapplied
+func ConnectWithoutMigrating(path string) (Store, error) {
+	if isSQLitePath(path) {
+		return ConnectSQLiteWithoutMigrating(path)
+	}
+
+	return ConnectBoltWithoutMigrating(path)
+}
+
+func GetCardsForToday(store Store) ([]Card, error) {
+	dueCards, err := store.DueCards(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	eligibleCards := []Card{}
+	for _, card := range dueCards {
+		if isDeckEnabled(card.ExerciseType) {
+			eligibleCards = append(eligibleCards, card)
+		}
+	}
+
+	// Take subset of cards for this session
+	eligibleCards = eligibleCards[:min(viper.GetInt("MaxItemsPerDay"), len(eligibleCards))]
+
+	// Shuffle cards
+	rand.Shuffle(len(eligibleCards), func(a, b int) {
+		eligibleCards[a], eligibleCards[b] = eligibleCards[b], eligibleCards[a]
+	})
+
+	return eligibleCards, nil
+}
+
+// Copy the database at one path into a new database at another. An existing
+// target is only replaced with force, after backing it up, as cards it has and
+// the source doesn't are removed. The source is left exactly as it is, so it
+// must already be up to date.
+func ConvertDatabase(fromPath, toPath string, force bool) (ImportReport, error) {
+	if _, err := os.Stat(fromPath); err != nil {
+		return ImportReport{}, err
+	}
+
+	_, err := os.Stat(toPath)
+	exists := err == nil
+	if exists && !force {
+		return ImportReport{}, fmt.Errorf("%s already exists (use -force to replace its cards, after backing it up)", toPath)
+	}
+
+	from, err := ConnectWithoutMigrating(fromPath)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer from.Close()
+
+	pending, err := from.Migrate(true)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	if pending.To != pending.From {
+		return ImportReport{}, fmt.Errorf("%s needs migrating from version %d to %d first", fromPath, pending.From, pending.To)
+	}
+
+	to, err := Connect(toPath)
+	if err != nil {
+		return ImportReport{}, err
+	}
+	defer to.Close()
+
+	if exists {
+		if _, err := BackupStore(to, toPath, viper.GetInt("BackupCount")); err != nil {
+			return ImportReport{}, fmt.Errorf("could not back up %s: %v", toPath, err)
+		}
+	}
+
+	return ConvertStore(from, to)
+}
+
+// Copy everything from one store into another, replacing existing cards.
+// Cards the source doesn't have, such as default cards added when the target
+// was created, are removed so cards deleted or quarantined in the source don't
+// come back.
+func ConvertStore(from, to Store) (ImportReport, error) {
+	data, err := ExportStore(from)
+	if err != nil {
+		return ImportReport{}, err
+	}
+
+	report, err := to.Import(data, MergeOverwrite)
+	if err != nil {
+		return report, err
+	}
+
+	keep := map[string]bool{}
+	for _, card := range data.Cards {
+		keep[string(card.Key())] = true
+	}
+
+	cards, err := to.AllCards()
+	if err != nil {
+		return report, err
+	}
+
+	for _, card := range cards {
+		if keep[string(card.Key())] {
+			continue
+		}
+
+		if err := to.DeleteCard(string(card.Key())); err != nil {
+			return report, err
+		}
+	}
+
+	cards, err = to.AllCards()
+	if err != nil {
+		return report, err
+	}
+
+	if len(cards) != len(data.Cards) {
+		return report, fmt.Errorf("converted database has %d cards, but the original has %d", len(cards), len(data.Cards))
+	}
+
+	settings, err := from.Settings()
+	if err != nil {
+		return report, err
+	}
+
+	for key, value := range settings {
+		if err := to.SetSetting(key, value); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
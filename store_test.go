@@ -0,0 +1,78 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestConvertDatabaseRefusesMissingSource(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "mistyped.db"), filepath.Join(dir, "new.db")
+
+	if _, err := ConvertDatabase(from, to, false); err == nil {
+		t.Fatal("converted a database which doesn't exist")
+	}
+
+	for _, path := range []string{from, to} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s was created by a failed conversion", filepath.Base(path))
+		}
+	}
+}
+
+func TestConvertDatabaseRefusesExistingTarget(t *testing.T) {
+	dir := t.TempDir()
+	from, to := filepath.Join(dir, "from.db"), filepath.Join(dir, "to.db")
+
+	source, err := ConnectBolt(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cards, err := source.AllCards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := source.DeleteCard(string(cards[0].Key())); err != nil {
+		t.Fatal(err)
+	}
+	source.Close()
+
+	target, err := ConnectBolt(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target.Close()
+
+	if _, err := ConvertDatabase(from, to, false); err == nil {
+		t.Fatal("converted into an existing database without -force")
+	}
+
+	target, err = ConnectBolt(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kept, err := target.AllCards(); err != nil || len(kept) != len(cards) {
+		t.Errorf("refused conversion left %d cards in the target (%v), want %d", len(kept), err, len(cards))
+	}
+	target.Close()
+
+	// With -force the target is backed up, then takes the source's cards
+	if _, err := ConvertDatabase(from, to, true); err != nil {
+		t.Fatal(err)
+	}
+
+	backups, err := ListBackups(to)
+	if err != nil || len(backups) != 1 {
+		t.Errorf("found backups %v (%v), want one", backups, err)
+	}
+
+	target, err = ConnectBolt(to)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer target.Close()
+	if converted, err := target.AllCards(); err != nil || len(converted) != len(cards)-1 {
+		t.Errorf("forced conversion left %d cards in the target (%v), want %d", len(converted), err, len(cards)-1)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+)
+
+// Name of the store kept in a sync directory for the given profile
+func SyncStoreName(profile string) string {
+	if profile == DefaultProfile {
+		return "chordy.db"
+	}
+
+	return "chordy-" + profile + ".db"
+}
+
+type SyncReport struct {
+	// Reviews copied from the remote store to the local one, and the other way
+	ReviewsPulled int
+	ReviewsPushed int
+	// Local cards whose schedule or metadata changed
+	CardsChanged int
+}
+
+// Merge two stores so both end up with the same cards and reviews. Reviews are
+// combined by ID, then each card's schedule is rebuilt by replaying its reviews
+// in order, so the result doesn't depend on which machine practiced first.
+// Suspension, burial and tags are taken from whichever copy of a card changed
+// them last.
+func Sync(local, remote Store) (SyncReport, error) {
+	var report SyncReport
+
+	localData, err := ExportStore(local)
+	if err != nil {
+		return report, err
+	}
+
+	remoteData, err := ExportStore(remote)
+	if err != nil {
+		return report, err
+	}
+
+	reviews := map[string]Review{}
+	for _, review := range localData.Reviews {
+		reviews[review.ID] = review
+	}
+	for _, review := range remoteData.Reviews {
+		if _, ok := reviews[review.ID]; !ok {
+			reviews[review.ID] = review
+			report.ReviewsPulled++
+		}
+	}
+	report.ReviewsPushed = len(reviews) - len(remoteData.Reviews)
+
+	merged, err := mergeSyncedCards(localData.Cards, remoteData.Cards)
+	if err != nil {
+		return report, err
+	}
+
+	// Review IDs sort by time, so replaying in ID order replays in time order
+	ids := []string{}
+	for id := range reviews {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	replayed := map[string]bool{}
+	for _, id := range ids {
+		review := reviews[id]
+		card, ok := merged[review.CardKey]
+		if !ok || review.Cram {
+			continue
+		}
+
+		// Start from the schedule the card had before its first review, which
+		// keeps any progress made before reviews were recorded
+		if !replayed[review.CardKey] {
+			card.Recalls = review.Recalls
+			card.Ef = review.Ef
+			card.Interval = review.Interval
+			replayed[review.CardKey] = true
+		}
+
+		merged[review.CardKey] = RecalculateCardAt(card, review.Grade, review.ReviewedAt)
+	}
+
+	data := Export{Version: LatestSchemaVersion()}
+	for _, id := range ids {
+		data.Reviews = append(data.Reviews, reviews[id])
+	}
+
+	keys := []string{}
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		data.Cards = append(data.Cards, merged[key])
+	}
+
+	report.CardsChanged, err = countChangedCards(localData.Cards, data.Cards)
+	if err != nil {
+		return report, err
+	}
+
+	if _, err := local.Import(data, MergeOverwrite); err != nil {
+		return report, err
+	}
+
+	if _, err := remote.Import(data, MergeOverwrite); err != nil {
+		return report, err
+	}
+
+	return report, nil
+}
+
+// Combine the cards of both stores by key. Cards only on one side are kept as
+// they are, and cards on both are combined by mergeSyncedCard.
+func mergeSyncedCards(localCards, remoteCards []Card) (map[string]Card, error) {
+	merged := map[string]Card{}
+	for _, card := range remoteCards {
+		merged[string(card.Key())] = card
+	}
+
+	for _, card := range localCards {
+		key := string(card.Key())
+		if remoteCard, ok := merged[key]; ok {
+			var err error
+			if card, err = mergeSyncedCard(card, remoteCard); err != nil {
+				return nil, err
+			}
+		}
+		merged[key] = card
+	}
+
+	return merged, nil
+}
+
+// Combine two copies of a card, giving the same result whichever order they're
+// passed in. Suspension, burial and tags come from the copy changed most
+// recently; if both changed at the same time, the card is suspended if either
+// copy is, buried until the later time, and has the tags of both. The schedule
+// comes from whichever copy was reviewed last, in case the card has no reviews
+// to replay.
+func mergeSyncedCard(a, b Card) (Card, error) {
+	if b.ChangedAt.After(a.ChangedAt) {
+		a, b = b, a
+	} else if b.ChangedAt.Equal(a.ChangedAt) {
+		first, err := a.Serialize()
+		if err != nil {
+			return a, err
+		}
+		second, err := b.Serialize()
+		if err != nil {
+			return a, err
+		}
+		if bytes.Compare(second, first) > 0 {
+			a, b = b, a
+		}
+	}
+
+	card := a
+	if a.ChangedAt.Equal(b.ChangedAt) {
+		card.Suspended = a.Suspended || b.Suspended
+		if b.BuriedUntil.After(a.BuriedUntil) {
+			card.BuriedUntil = b.BuriedUntil
+		}
+
+		card.Tags = append([]string{}, a.Tags...)
+		for _, tag := range b.Tags {
+			card.AddTag(tag)
+		}
+	}
+
+	if b.LastRecalledAt.After(a.LastRecalledAt) {
+		card.Recalls = b.Recalls
+		card.Ef = b.Ef
+		card.Interval = b.Interval
+		card.LastRecalledAt = b.LastRecalledAt
+	}
+
+	return card, nil
+}
+
+func countChangedCards(before, after []Card) (int, error) {
+	original := map[string][]byte{}
+	for _, card := range before {
+		data, err := card.Serialize()
+		if err != nil {
+			return 0, err
+		}
+		original[string(card.Key())] = data
+	}
+
+	changed := 0
+	for _, card := range after {
+		data, err := card.Serialize()
+		if err != nil {
+			return 0, err
+		}
+
+		if !bytes.Equal(original[string(card.Key())], data) {
+			changed++
+		}
+	}
+
+	return changed, nil
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+var syncTestStart = time.Date(2021, 3, 1, 9, 0, 0, 0, time.UTC)
+
+func openTestStore(t *testing.T, name string) Store {
+	t.Helper()
+
+	store, err := ConnectBolt(filepath.Join(t.TempDir(), name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Close)
+
+	return store
+}
+
+// An independent copy of a store
+func copyTestStore(t *testing.T, store Store, name string) Store {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := store.Backup(path); err != nil {
+		t.Fatal(err)
+	}
+
+	copied, err := ConnectBolt(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(copied.Close)
+
+	return copied
+}
+
+func testCard(t *testing.T, store Store, key string) Card {
+	t.Helper()
+
+	cards, err := store.AllCards()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, card := range cards {
+		if string(card.Key()) == key {
+			return card
+		}
+	}
+
+	t.Fatalf("no card with key %q", key)
+	return Card{}
+}
+
+// Grade a card as if it was practised at the given time
+func gradeTestCard(t *testing.T, store Store, key string, grade uint, at time.Time) {
+	t.Helper()
+
+	card := testCard(t, store, key)
+	review := NewReview(card, grade, at)
+	if err := store.UpsertWithReview(RecalculateCardAt(card, grade, at), review); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Suspend, bury or tag a card as if it was done at the given time
+func changeTestCard(t *testing.T, store Store, key string, at time.Time, change func(*Card)) {
+	t.Helper()
+
+	card := testCard(t, store, key)
+	change(&card)
+	card.ChangedAt = at
+	if err := store.Upsert(card); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// Two stores which have each practised some of the same cards, and changed
+// the metadata of others, since they last synced
+func divergedStores(t *testing.T) (Store, Store, []string) {
+	t.Helper()
+
+	local := openTestStore(t, "local.db")
+	remote := openTestStore(t, "remote.db")
+
+	cards, err := local.AllCards()
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, localOnly, remoteOnly, retagged := string(cards[0].Key()), string(cards[1].Key()), string(cards[2].Key()), string(cards[3].Key())
+
+	gradeTestCard(t, local, shared, 4, syncTestStart)
+	gradeTestCard(t, remote, shared, 5, syncTestStart.Add(time.Hour))
+	gradeTestCard(t, local, shared, 2, syncTestStart.AddDate(0, 0, 1))
+	gradeTestCard(t, local, localOnly, 3, syncTestStart.Add(2*time.Hour))
+	gradeTestCard(t, remote, remoteOnly, 5, syncTestStart.Add(3*time.Hour))
+
+	// The remote change to the shared card is later, so wins
+	changeTestCard(t, local, shared, syncTestStart.AddDate(0, 0, 2), func(card *Card) { card.AddTag("warmup") })
+	changeTestCard(t, remote, shared, syncTestStart.AddDate(0, 0, 3), func(card *Card) {
+		card.Suspended = true
+		card.AddTag("tricky")
+	})
+
+	// Changes made at the same time are combined
+	changeTestCard(t, local, retagged, syncTestStart.AddDate(0, 0, 2), func(card *Card) {
+		card.Bury(syncTestStart.AddDate(0, 0, 2))
+		card.AddTag("scales")
+	})
+	changeTestCard(t, remote, retagged, syncTestStart.AddDate(0, 0, 2), func(card *Card) { card.AddTag("arpeggios") })
+
+	return local, remote, []string{shared, localOnly, remoteOnly, retagged}
+}
+
+func exportTestStore(t *testing.T, store Store) Export {
+	t.Helper()
+
+	data, err := ExportStore(store)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return data
+}
+
+func TestSyncConvergesInEitherOrder(t *testing.T) {
+	local, remote, _ := divergedStores(t)
+	otherLocal, otherRemote := copyTestStore(t, local, "local.db"), copyTestStore(t, remote, "remote.db")
+
+	if _, err := Sync(local, remote); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Sync(otherRemote, otherLocal); err != nil {
+		t.Fatal(err)
+	}
+
+	want := exportTestStore(t, local)
+	if len(want.Reviews) != 5 {
+		t.Errorf("got %d reviews after syncing, want 5", len(want.Reviews))
+	}
+
+	for name, store := range map[string]Store{"remote": remote, "local synced second": otherLocal, "remote synced first": otherRemote} {
+		if got := exportTestStore(t, store); !reflect.DeepEqual(got, want) {
+			t.Errorf("%s store differs from the local store after syncing", name)
+		}
+	}
+}
+
+func TestSyncTwiceDoesNotDuplicateReviews(t *testing.T) {
+	local, remote, _ := divergedStores(t)
+
+	report, err := Sync(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ReviewsPulled != 2 || report.ReviewsPushed != 3 {
+		t.Errorf("first sync pulled %d and pushed %d reviews, want 2 and 3", report.ReviewsPulled, report.ReviewsPushed)
+	}
+
+	report, err = Sync(local, remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ReviewsPulled != 0 || report.ReviewsPushed != 0 || report.CardsChanged != 0 {
+		t.Errorf("second sync changed something: %+v", report)
+	}
+
+	for name, store := range map[string]Store{"local": local, "remote": remote} {
+		if n := len(exportTestStore(t, store).Reviews); n != 5 {
+			t.Errorf("%s store has %d reviews after syncing twice, want 5", name, n)
+		}
+	}
+}
+
+func TestSyncReplaysMergedReviews(t *testing.T) {
+	local, remote, keys := divergedStores(t)
+	original := testCard(t, local, keys[0])
+	original.Recalls, original.Ef, original.Interval, original.LastRecalledAt = 0, 2.5, 0, time.Time{}
+
+	// Cram reviews are merged but don't change the schedule
+	cram := NewReview(original, 5, syncTestStart.Add(30*time.Minute))
+	cram.Cram = true
+	if err := remote.AddReview(cram); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Sync(local, remote); err != nil {
+		t.Fatal(err)
+	}
+
+	want := RecalculateCardAt(original, 4, syncTestStart)
+	want = RecalculateCardAt(want, 5, syncTestStart.Add(time.Hour))
+	want = RecalculateCardAt(want, 2, syncTestStart.AddDate(0, 0, 1))
+
+	for name, store := range map[string]Store{"local": local, "remote": remote} {
+		got := testCard(t, store, keys[0])
+		if got.Recalls != want.Recalls || got.Ef != want.Ef || got.Interval != want.Interval || !got.LastRecalledAt.Equal(want.LastRecalledAt) {
+			t.Errorf("%s schedule is recalls %d, ef %v, interval %d, last recalled %v; want %d, %v, %d, %v", name,
+				got.Recalls, got.Ef, got.Interval, got.LastRecalledAt, want.Recalls, want.Ef, want.Interval, want.LastRecalledAt)
+		}
+	}
+
+	// Cards practised on one side only take that side's schedule
+	for _, key := range keys[1:3] {
+		if got := testCard(t, local, key); got.Recalls != 1 || got.Interval != 1 {
+			t.Errorf("card %s has recalls %d and interval %d after syncing, want 1 and 1", key, got.Recalls, got.Interval)
+		}
+	}
+}
+
+func TestSyncMergesCardMetadata(t *testing.T) {
+	local, remote, keys := divergedStores(t)
+	buried := testCard(t, local, keys[3]).BuriedUntil
+
+	if _, err := Sync(local, remote); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, store := range map[string]Store{"local": local, "remote": remote} {
+		if got := testCard(t, store, keys[0]); !got.Suspended || !reflect.DeepEqual(got.Tags, []string{"tricky"}) {
+			t.Errorf("%s shared card has suspended %v and tags %v, want the later remote change", name, got.Suspended, got.Tags)
+		}
+
+		got := testCard(t, store, keys[3])
+		if got.Suspended || !got.BuriedUntil.Equal(buried) || !reflect.DeepEqual(got.Tags, []string{"arpeggios", "scales"}) {
+			t.Errorf("%s card changed on both sides at once has suspended %v, buried until %v and tags %v; want both changes combined",
+				name, got.Suspended, got.BuriedUntil, got.Tags)
+		}
+	}
+}
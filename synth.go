@@ -0,0 +1,179 @@
+package main
+
+import (
+	"github.com/gpayer/go-audio-service/generators"
+	"github.com/gpayer/go-audio-service/notes"
+	"github.com/gpayer/go-audio-service/snd"
+	"github.com/spf13/viper"
+	"gitlab.com/gomidi/midi"
+	"gitlab.com/gomidi/midi/writer"
+	"gitlab.com/gomidi/rtmididrv"
+	"sync"
+)
+
+// Something which sounds the notes being played and exercises played back.
+// Notes can be sent from several goroutines.
+type Synth interface {
+	NoteOn(key, velocity uint8)
+	NoteOff(key uint8)
+	// Hold notes which are released until the pedal is lifted
+	Sustain(on bool)
+	Close()
+}
+
+// Open the synth chosen by the MidiOutput setting, or the built-in one if
+// there's no output set
+func OpenSynth() (Synth, error) {
+	if selector := viper.GetString("MidiOutput"); selector != "" {
+		return NewMidiSynth(selector)
+	}
+
+	return NewInternalSynth()
+}
+
+// The built-in square wave synth
+type InternalSynth struct {
+	output *snd.Output
+	multi  *notes.NoteMultiplexer
+
+	// Guards the sustain state
+	mu sync.Mutex
+	// Whether the pedal is down, and the notes released while it was
+	sustain   bool
+	sustained map[uint8]bool
+}
+
+func NewInternalSynth() (*InternalSynth, error) {
+	output, err := snd.NewOutput(44000, 512)
+	if err != nil {
+		return nil, err
+	}
+
+	rect := generators.NewRect(44000, 440.0)
+	multi := notes.NewNoteMultiplexer()
+	multi.SetReadable(rect)
+	output.SetReadable(multi)
+
+	err = output.Start()
+	if err != nil {
+		return nil, err
+	}
+
+	return &InternalSynth{output: output, multi: multi, sustained: map[uint8]bool{}}, nil
+}
+
+func (self *InternalSynth) send(kind int, key, velocity uint8) {
+	note := notes.MidiToNote(int64(key))
+	self.multi.SendNoteEvent(notes.NewNoteEvent(kind, note, float32(velocity)/127))
+}
+
+func (self *InternalSynth) NoteOn(key, velocity uint8) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	// Stop a sustained note before playing it again
+	if self.sustained[key] {
+		self.send(notes.Released, key, 0)
+		delete(self.sustained, key)
+	}
+
+	self.send(notes.Pressed, key, velocity)
+}
+
+func (self *InternalSynth) NoteOff(key uint8) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if self.sustain {
+		self.sustained[key] = true
+		return
+	}
+
+	self.send(notes.Released, key, 0)
+}
+
+func (self *InternalSynth) Sustain(on bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	self.sustain = on
+	if on {
+		return
+	}
+
+	for key := range self.sustained {
+		self.send(notes.Released, key, 0)
+	}
+	self.sustained = map[uint8]bool{}
+}
+
+func (self *InternalSynth) Close() {
+	_ = self.output.Stop()
+}
+
+// Sent to external synths to stop every note
+const AllNotesOffController = 123
+
+// An external synth, such as a keyboard's own sounds or a software instrument
+// in a DAW, played over a MIDI output on the first channel
+type MidiSynth struct {
+	driver *rtmididrv.Driver
+	out    midi.Out
+
+	// Guards the writer
+	mu sync.Mutex
+	wr *writer.Writer
+}
+
+func NewMidiSynth(selector string) (*MidiSynth, error) {
+	driver, err := rtmididrv.New()
+	if err != nil {
+		return nil, err
+	}
+
+	out, err := openOutput(driver, selector)
+	if err != nil {
+		driver.Close()
+		return nil, err
+	}
+
+	return &MidiSynth{driver: driver, out: out, wr: writer.New(out)}, nil
+}
+
+// Errors aren't reported, as the output may be unplugged at any time and the
+// synth is only for listening
+func (self *MidiSynth) NoteOn(key, velocity uint8) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	_ = writer.NoteOn(self.wr, key, velocity)
+}
+
+func (self *MidiSynth) NoteOff(key uint8) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	_ = writer.NoteOff(self.wr, key)
+}
+
+func (self *MidiSynth) Sustain(on bool) {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if on {
+		_ = writer.CcOn(self.wr, SustainController)
+	} else {
+		_ = writer.CcOff(self.wr, SustainController)
+	}
+}
+
+// Stop anything still sounding before closing the output
+func (self *MidiSynth) Close() {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	_ = writer.CcOff(self.wr, SustainController)
+	_ = writer.ControlChange(self.wr, AllNotesOffController, 0)
+	_ = self.out.Close()
+	self.driver.Close()
+}